@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+type albumBatchInput struct {
+	Op      string   `json:"op"`
+	ID      int64    `json:"id"`
+	Version *int32   `json:"version"`
+	Title   *string  `json:"title"`
+	Artist  *string  `json:"artist"`
+	Genres  []string `json:"genres"`
+	Year    *int32   `json:"year"`
+}
+
+type albumBatchResult struct {
+	Index  int               `json:"index"`
+	Op     string            `json:"op"`
+	Status int               `json:"status"`
+	Album  *data.Album       `json:"album,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+func (app *application) batchAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string            `json:"mode"`
+		Operations []albumBatchInput `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Mode == "" {
+		input.Mode = "transactional"
+	}
+
+	v := validator.New()
+
+	v.Check(validator.PermittedValue(input.Mode, "transactional", "partial"), "mode", "must be transactional or partial")
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= app.config.batch.maxOperations, "operations", fmt.Sprintf("must not contain more than %d operations", app.config.batch.maxOperations))
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]*albumBatchResult, len(input.Operations))
+	var ops []*data.BatchOperation
+	var opResults []*albumBatchResult
+	var failed []*albumBatchResult
+
+	for i, in := range input.Operations {
+		results[i] = &albumBatchResult{Index: i, Op: in.Op}
+
+		op, errs, err := app.prepareBatchOperation(in)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if errs != nil {
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].Errors = errs
+		} else if op.Err != nil {
+			results[i].setError(op.Err)
+		}
+
+		if results[i].Status != 0 {
+			failed = append(failed, results[i])
+			continue
+		}
+
+		ops = append(ops, op)
+		opResults = append(opResults, results[i])
+	}
+
+	atomic := input.Mode == "transactional"
+
+	if atomic && len(failed) > 0 {
+		app.errorResponse(w, r, failed[0].Status, failed)
+		return
+	}
+
+	err = app.models.Albums.Batch(ops, atomic, app.actor(r))
+	if err != nil {
+		if atomic {
+			for i, op := range ops {
+				if errors.Is(op.Err, data.ErrRecordNotFound) || errors.Is(op.Err, data.ErrEditConflict) {
+					opResults[i].setError(op.Err)
+					app.errorResponse(w, r, opResults[i].Status, []*albumBatchResult{opResults[i]})
+					return
+				}
+			}
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for i, op := range ops {
+		result := opResults[i]
+
+		switch {
+		case op.Err == nil:
+			result.Status = http.StatusOK
+			if op.Op == data.BatchCreate {
+				result.Status = http.StatusCreated
+			}
+			result.Album = op.Album
+		case errors.Is(op.Err, data.ErrRecordNotFound), errors.Is(op.Err, data.ErrEditConflict):
+			result.setError(op.Err)
+		default:
+			app.logError(r, op.Err)
+			result.Status = http.StatusInternalServerError
+			result.Error = "the server encountered an issue and cannot process this operation"
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// prepareBatchOperation turns one requested operation into a model operation,
+// loading the album for updates and deletes. It returns the validation errors
+// for an invalid operation; a missing album or stale version is reported in
+// the operation's Err.
+func (app *application) prepareBatchOperation(in albumBatchInput) (*data.BatchOperation, map[string]string, error) {
+	v := validator.New()
+
+	v.Check(validator.PermittedValue(in.Op, data.BatchOperationSafelist...), "op", "must be create, update or delete")
+	v.Check(in.Op == data.BatchCreate || in.ID > 0, "id", "must be provided")
+
+	if !v.Valid() {
+		return nil, v.Errors, nil
+	}
+
+	op := &data.BatchOperation{Op: in.Op, Album: &data.Album{}}
+
+	if in.Op != data.BatchCreate {
+		album, err := app.models.Albums.Get(in.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				op.Err = err
+				return op, nil, nil
+			}
+			return nil, nil, err
+		}
+
+		if in.Version != nil && *in.Version != album.Version {
+			op.Err = data.ErrEditConflict
+			return op, nil, nil
+		}
+
+		op.Album = album
+
+		if in.Op == data.BatchDelete {
+			return op, nil, nil
+		}
+	}
+
+	if in.Title != nil {
+		op.Album.Title = *in.Title
+	}
+
+	if in.Artist != nil {
+		op.Album.Artist = *in.Artist
+	}
+
+	if in.Genres != nil {
+		op.Album.Genres = in.Genres
+	}
+
+	if in.Year != nil {
+		op.Album.Year = *in.Year
+	}
+
+	if data.ValidateAlbum(v, op.Album); !v.Valid() {
+		return nil, v.Errors, nil
+	}
+
+	return op, nil, nil
+}
+
+func (res *albumBatchResult) setError(err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		res.Status = http.StatusNotFound
+		res.Error = "resource not found"
+	case errors.Is(err, data.ErrEditConflict):
+		res.Status = http.StatusConflict
+		res.Error = "unable to update the record due to an edit conflict -- please try again"
+	}
+}
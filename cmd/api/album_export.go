@@ -0,0 +1,223 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// exportBatchSize is how many albums are read from the database at a time
+// while streaming an export.
+const exportBatchSize = 1000
+
+// exportWriteTimeout is how long each batch of an export has to be written.
+// It replaces the server's write timeout, which would otherwise cut off an
+// export of a large catalog part way through.
+const exportWriteTimeout = 30 * time.Second
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json",
+}
+
+// albumExporter writes albums to an export as they're read.
+type albumExporter interface {
+	begin() error
+	write(album *data.Album) error
+	end() error
+}
+
+func (app *application) exportAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "json")
+	compress := app.readBool(qs, "gzip", strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"), v)
+
+	v.Check(validator.PermittedValue(format, "csv", "ndjson", "json"), "format", "must be csv, ndjson or json")
+
+	input := app.readAlbumQuery(qs, v)
+	input.UserID = app.contextGetUser(r).ID
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	// Read the first batch before writing anything, so an error can still be
+	// reported with a proper response.
+	albums, err := app.models.Albums.GetBatch(input.AlbumSearch, exportBatchSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="albums.%s"`, format))
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = w
+
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	var exporter albumExporter
+	switch format {
+	case "csv":
+		exporter = &csvAlbumExporter{w: csv.NewWriter(out)}
+	case "ndjson":
+		exporter = &ndjsonAlbumExporter{enc: json.NewEncoder(out)}
+	default:
+		exporter = &jsonAlbumExporter{w: out}
+	}
+
+	err = app.streamAlbums(w, out, exporter, input.AlbumSearch, albums)
+	if err != nil {
+		// The status line has already been sent, so all that can be done is to
+		// stop writing and log why the export is incomplete.
+		app.logError(r, err)
+	}
+}
+
+func (app *application) streamAlbums(w http.ResponseWriter, out io.Writer, exporter albumExporter, search data.AlbumSearch, albums []*data.Album) error {
+	rc := http.NewResponseController(w)
+
+	extendDeadline := func() error {
+		err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	err := extendDeadline()
+	if err != nil {
+		return err
+	}
+
+	err = exporter.begin()
+	if err != nil {
+		return err
+	}
+
+	for {
+		for _, album := range albums {
+			err := exporter.write(album)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(albums) < exportBatchSize {
+			break
+		}
+
+		if gz, ok := out.(*gzip.Writer); ok {
+			gz.Flush()
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		search.AfterID = albums[len(albums)-1].ID
+
+		albums, err = app.models.Albums.GetBatch(search, exportBatchSize)
+		if err != nil {
+			return err
+		}
+
+		err = extendDeadline()
+		if err != nil {
+			return err
+		}
+	}
+
+	return exporter.end()
+}
+
+type csvAlbumExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvAlbumExporter) begin() error {
+	return e.w.Write([]string{"id", "title", "artist", "genres", "year"})
+}
+
+func (e *csvAlbumExporter) write(album *data.Album) error {
+	year := ""
+	if album.Year != 0 {
+		year = strconv.Itoa(int(album.Year))
+	}
+
+	return e.w.Write([]string{strconv.FormatInt(album.ID, 10), album.Title, album.Artist, strings.Join(album.Genres, ";"), year})
+}
+
+func (e *csvAlbumExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonAlbumExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonAlbumExporter) begin() error {
+	return nil
+}
+
+func (e *ndjsonAlbumExporter) write(album *data.Album) error {
+	return e.enc.Encode(album)
+}
+
+func (e *ndjsonAlbumExporter) end() error {
+	return nil
+}
+
+// jsonAlbumExporter writes the same {"albums": [...]} envelope as the album
+// listing, one album at a time.
+type jsonAlbumExporter struct {
+	w       io.Writer
+	written bool
+}
+
+func (e *jsonAlbumExporter) begin() error {
+	_, err := io.WriteString(e.w, "{\"albums\": [")
+	return err
+}
+
+func (e *jsonAlbumExporter) write(album *data.Album) error {
+	js, err := json.Marshal(album)
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n"
+	if !e.written {
+		separator = "\n"
+		e.written = true
+	}
+
+	_, err = io.WriteString(e.w, separator+string(js))
+	return err
+}
+
+func (e *jsonAlbumExporter) end() error {
+	_, err := io.WriteString(e.w, "\n]}\n")
+	return err
+}
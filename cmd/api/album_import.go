@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/importer"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func (app *application) importAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	if app.readString(qs, "source", "") == importer.DiscogsSource {
+		app.importDiscogsHandler(w, r)
+		return
+	}
+
+	defaultFormat := "csv"
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/tab-separated-values" {
+		defaultFormat = "tsv"
+	}
+
+	format := app.readString(qs, "format", defaultFormat)
+	genreSeparator := app.readString(qs, "genre_separator", ";")
+	opts := importer.Options{
+		DryRun:          app.readBool(qs, "dry_run", false, v),
+		AllowDuplicates: app.readBool(qs, "allow_duplicates", false, v),
+	}
+	async := app.readBool(qs, "async", false, v)
+
+	v.Check(validator.PermittedValue(format, "csv", "tsv"), "format", "must be csv or tsv")
+
+	mapping, err := importer.ParseMapping(app.readString(qs, "mapping", ""))
+	if err != nil {
+		v.AddError("mapping", err.Error())
+	}
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	csvOpts := importer.CSVOptions{Comma: ',', Mapping: mapping, GenreSeparator: genreSeparator}
+	if format == "tsv" {
+		csvOpts.Comma = '\t'
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	rows, err := importer.ReadCSV(r.Body, csvOpts)
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			err = fmt.Errorf("body must not be larger than %d bytes", app.config.imports.maxBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(rows) > 0, "file", "must contain at least 1 album")
+	v.Check(len(rows) <= app.config.imports.maxRows, "file", fmt.Sprintf("must not contain more than %d albums", app.config.imports.maxRows))
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	actor := app.actor(r)
+
+	if async {
+		job, err := app.startJob(r, "album_import", len(rows), func(progress func(processed, total int)) (interface{}, error) {
+			opts.Progress = progress
+
+			report, err := importer.Import(app.models.Albums, rows, actor, opts)
+			if report == nil {
+				return nil, err
+			}
+			return report, err
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		headers := make(http.Header)
+		headers.Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	report, err := importer.Import(app.models.Albums, rows, actor, opts)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if opts.DryRun {
+		status = http.StatusOK
+	}
+
+	err = app.writeJSON(w, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
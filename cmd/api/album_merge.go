@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func (app *application) listDuplicateAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	minScore := app.readFloat(qs, "min_score", 0.6, v)
+	limit := app.readInt(qs, "limit", 50, v)
+
+	v.Check(minScore >= 0.3 && minScore <= 1, "min_score", "must be between 0.3 and 1")
+	v.Check(limit > 0 && limit <= 200, "limit", "must be between 1 and 200")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	candidates, err := app.models.Albums.FindDuplicates(minScore, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"duplicates": candidates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) mergeAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AlbumID int64  `json:"album_id"`
+		Version *int32 `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.AlbumID > 0, "album_id", "must be provided")
+	v.Check(input.AlbumID != id, "album_id", "must not be the album being merged into")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	album, err := app.models.Albums.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Version != nil && *input.Version != album.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	merged, err := app.models.Albums.Merge(album, input.AlbumID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("album_id", "must be an existing album")
+			app.failedValidationsResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"album": album, "merged": merged}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/davemolk/recordAPI/internal/data"
 	"github.com/davemolk/recordAPI/internal/validator"
@@ -14,10 +15,10 @@ func (app *application) createAlbumHandler(w http.ResponseWriter, r *http.Reques
 		Title  string   `json:"title"`
 		Artist string   `json:"artist"`
 		Genres []string `json:"genres"`
+		Year   int32    `json:"year"`
 	}
 
 	err := app.readJSON(w, r, &input)
-	fmt.Println(input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -27,6 +28,7 @@ func (app *application) createAlbumHandler(w http.ResponseWriter, r *http.Reques
 		Title:  input.Title,
 		Artist: input.Artist,
 		Genres: input.Genres,
+		Year:   input.Year,
 	}
 
 	v := validator.New()
@@ -36,7 +38,7 @@ func (app *application) createAlbumHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Albums.Insert(album)
+	err = app.models.Albums.Insert(album, app.actor(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -75,36 +77,160 @@ func (app *application) showAlbumHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (app *application) listAlbumsHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title  string
-		Artist string
-		Genres []string
-		data.Filters
-	}
+type albumQuery struct {
+	data.AlbumSearch
+	Facets []string
+	data.Filters
+}
 
-	v := validator.New()
-	qs := r.URL.Query()
+// readAlbumQuery reads and validates the album listing parameters, which are
+// shared by GET /v1/albums and saved searches.
+func (app *application) readAlbumQuery(qs url.Values, v *validator.Validator) albumQuery {
+	var input albumQuery
 
+	input.Query = app.readString(qs, "q", "")
 	input.Title = app.readString(qs, "title", "")
 	input.Artist = app.readString(qs, "artist", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Subgenres = app.readBool(qs, "subgenres", false, v)
+	input.Tags = data.NormalizeTags(app.readCSV(qs, "tags", []string{}))
+	input.Decade = app.readInt(qs, "decade", 0, v)
+	input.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	filter := app.readString(qs, "filter", "")
+	input.Facets = app.readCSV(qs, "facets", []string{})
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "artist", "-id", "-title", "-artist"}
+	defaultSort := "id"
+	if input.HasQuery() {
+		defaultSort = "relevance"
+	}
 
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "artist", "relevance", "-id", "-title", "-artist"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+
+	// A cursor continues the kind of search that issued it, so the pages
+	// after an automatic fuzzy fallback stay fuzzy.
+	if input.Filters.Cursor != "" {
+		input.Fuzzy = input.Filters.FuzzyCursor()
+	}
+
+	for i, genre := range input.Genres {
+		if name, ok := data.LookupGenre(genre); ok {
+			input.Genres[i] = name
+		}
+	}
+
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, data.FacetSafelist...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(input.Facets), "facets", "no duplicate values")
+	v.Check(len(input.Tags) <= 20, "tags", "must not contain more than 20 tags")
+
+	if v.Check(len(filter) <= 1000, "filter", "must not be more than 1000 bytes long"); v.Valid() {
+		expr, err := data.ParseFilter(filter)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+		input.Filter = expr
+	}
+
+	data.ValidateAlbumSearch(v, input.AlbumSearch)
+	v.Check(input.Filters.Sort != "relevance" || input.HasQuery(), "sort", "relevance sort requires a q parameter with words to search for")
+
+	data.ValidateFilters(v, input.Filters)
+
+	return input
+}
+
+func (app *application) listAlbums(input albumQuery) (envelope, error) {
+	albums, metadata, err := app.models.Albums.GetAll(input.AlbumSearch, input.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(albums) == 0 && !input.Fuzzy && input.HasText() {
+		input.Fuzzy, err = app.noExactMatches(input)
+		if err != nil {
+			return nil, err
+		}
+
+		if input.Fuzzy {
+			albums, metadata, err = app.models.Albums.GetAll(input.AlbumSearch, input.Filters)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	env := envelope{"albums": albums, "metadata": metadata}
+
+	if input.Fuzzy {
+		env["fuzzy"] = true
+
+		suggestion, err := app.models.Albums.Suggest(input.AlbumSearch)
+		if err != nil {
+			return nil, err
+		}
+		if suggestion != "" {
+			env["did_you_mean"] = suggestion
+		}
+	}
+
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Albums.GetFacets(input.AlbumSearch, input.Facets, 20)
+		if err != nil {
+			return nil, err
+		}
+		env["facets"] = facets
+	}
+
+	return env, nil
+}
+
+// noExactMatches reports whether a text search that returned an empty page
+// matches nothing at all, and so should be retried as a fuzzy search. A
+// later page may be empty only because it's past the last match. Pages
+// reached by a cursor from an exact search never fall back.
+func (app *application) noExactMatches(input albumQuery) (bool, error) {
+	if input.Filters.Cursor != "" {
+		return false, nil
+	}
+	if input.Filters.Page == 1 {
+		return true, nil
+	}
+
+	filters := input.Filters
+	filters.Page = 1
+	filters.PageSize = 1
+	filters.Count = data.CountNone
+
+	albums, _, err := app.models.Albums.GetAll(input.AlbumSearch, filters)
+	if err != nil {
+		return false, err
+	}
+
+	return len(albums) == 0, nil
+}
+
+func (app *application) listAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	input := app.readAlbumQuery(r.URL.Query(), v)
+	input.UserID = app.contextGetUser(r).ID
+	if !v.Valid() {
 		app.failedValidationsResponse(w, r, v.Errors)
 		return
 	}
 
-	albums, metadata, err := app.models.Albums.GetAll(input.Title, input.Artist, input.Genres, input.Filters)
+	env, err := app.listAlbums(input)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"albums": albums, "metadata": metadata}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -132,6 +258,7 @@ func (app *application) updateAlbumHandler(w http.ResponseWriter, r *http.Reques
 		Title  *string  `json:"title"`
 		Artist *string  `json:"artist"`
 		Genres []string `json:"genres"`
+		Year   *int32   `json:"year"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -152,6 +279,10 @@ func (app *application) updateAlbumHandler(w http.ResponseWriter, r *http.Reques
 		album.Genres = input.Genres
 	}
 
+	if input.Year != nil {
+		album.Year = *input.Year
+	}
+
 	v := validator.New()
 
 	if data.ValidateAlbum(v, album); !v.Valid() {
@@ -159,7 +290,7 @@ func (app *application) updateAlbumHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Albums.Update(album)
+	err = app.models.Albums.Update(album, app.actor(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -183,7 +314,85 @@ func (app *application) deleteAlbumHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Albums.Delete(id)
+	err = app.models.Albums.Delete(id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "album moved to trash"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listDeletedAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "artist", "deleted_at", "-id", "-title", "-artist", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	albums, metadata, err := app.models.Albums.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"albums": albums, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) undeleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	album, err := app.models.Albums.Undelete(id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"album": album}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) purgeAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Albums.Purge(id, app.actor(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -194,7 +403,7 @@ func (app *application) deleteAlbumHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "album deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "album permanently deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func (app *application) listAuditEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilters
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.TargetID = int64(app.readInt(qs, "target_id", 0, v))
+	input.Action = app.readString(qs, "action", "")
+	input.From = app.readTime(qs, "from", v)
+	input.To = app.readTime(qs, "to", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "-id"}
+
+	if input.From != nil && input.To != nil {
+		v.Check(input.From.Before(*input.To), "from", "must be before to")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(input.AuditFilters, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) verifyAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	brokenID, checked, err := app.models.Audit.Verify()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"valid":   brokenID == 0,
+		"checked": checked,
+	}
+	if brokenID != 0 {
+		env["first_invalid_id"] = brokenID
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
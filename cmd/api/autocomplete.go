@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func (app *application) autocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Type  string
+		Limit int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Query = strings.TrimSpace(app.readString(qs, "q", ""))
+	input.Type = app.readString(qs, "type", "artist")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.Check(input.Query != "", "q", "must be provided")
+	v.Check(len(input.Query) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(validator.PermittedValue(input.Type, data.AutocompleteSafelist...), "type", "must be artist, title or genre")
+	v.Check(input.Limit > 0, "limit", "must be greater than 0")
+	v.Check(input.Limit <= 25, "limit", "maximum of 25")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	key := fmt.Sprintf("%s:%d:%s", input.Type, input.Limit, strings.ToLower(input.Query))
+
+	suggestions, ok := app.autocomplete.Get(key)
+	if !ok {
+		var err error
+		suggestions, err = app.models.Albums.Autocomplete(input.Type, input.Query, input.Limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.autocomplete.Set(key, suggestions)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func (app *application) createCopyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AlbumID    int64      `json:"album_id"`
+		Condition  string     `json:"condition"`
+		Notes      string     `json:"notes"`
+		AcquiredAt *time.Time `json:"acquired_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	copy := &data.Copy{
+		UserID:     app.contextGetUser(r).ID,
+		AlbumID:    input.AlbumID,
+		Condition:  input.Condition,
+		Notes:      input.Notes,
+		AcquiredAt: input.AcquiredAt,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCopy(v, copy); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Copies.Insert(copy, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("album_id", "must be an existing album")
+			app.failedValidationsResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the copy back so the response includes its album.
+	copy, err = app.models.Copies.Get(copy.ID, copy.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/copies/%d", copy.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"copy": copy}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCopiesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	albumID := int64(app.readInt(qs, "album_id", 0, v))
+
+	var filters data.Filters
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "artist")
+	filters.SortSafelist = []string{"artist", "title", "acquired_at", "-artist", "-title", "-acquired_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	copies, metadata, err := app.models.Copies.GetAllForUser(app.contextGetUser(r).ID, albumID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copies": copies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCopyHandler(w http.ResponseWriter, r *http.Request) {
+	copy, ok := app.copyForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"copy": copy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCopyHandler(w http.ResponseWriter, r *http.Request) {
+	copy, ok := app.copyForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Condition  *string    `json:"condition"`
+		Notes      *string    `json:"notes"`
+		AcquiredAt *time.Time `json:"acquired_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Condition != nil {
+		copy.Condition = *input.Condition
+	}
+
+	if input.Notes != nil {
+		copy.Notes = *input.Notes
+	}
+
+	if input.AcquiredAt != nil {
+		copy.AcquiredAt = input.AcquiredAt
+	}
+
+	v := validator.New()
+
+	if data.ValidateCopy(v, copy); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Copies.Update(copy, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": copy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCopyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Copies.Delete(id, app.contextGetUser(r).ID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "copy successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) copyForRequest(w http.ResponseWriter, r *http.Request) (*data.Copy, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	copy, err := app.models.Copies.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return copy, true
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/importer"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func readDiscogsReleases(r io.Reader, format string) ([]*importer.DiscogsRelease, error) {
+	if format == "json" {
+		return importer.ReadDiscogsJSON(r)
+	}
+	return importer.ReadDiscogsCSV(r)
+}
+
+// importDiscogsHandler serves POST /v1/albums/import?source=discogs. The
+// import always runs as a background job.
+func (app *application) importDiscogsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	defaultFormat := "csv"
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		defaultFormat = "json"
+	}
+
+	format := app.readString(qs, "format", defaultFormat)
+	opts := importer.Options{
+		DryRun:          app.readBool(qs, "dry_run", false, v),
+		AllowDuplicates: app.readBool(qs, "allow_duplicates", false, v),
+	}
+
+	v.Check(validator.PermittedValue(format, "csv", "json"), "format", "must be csv or json")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	releases, err := readDiscogsReleases(r.Body, format)
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			err = fmt.Errorf("body must not be larger than %d bytes", app.config.imports.maxBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(releases) > 0, "file", "must contain at least 1 release")
+	v.Check(len(releases) <= app.config.imports.maxRows, "file", fmt.Sprintf("must not contain more than %d releases", app.config.imports.maxRows))
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	actor := app.actor(r)
+
+	job, err := app.startJob(r, "discogs_import", len(releases), func(progress func(processed, total int)) (interface{}, error) {
+		opts.Progress = progress
+
+		report, err := importer.ImportDiscogs(app.models, releases, actor, opts)
+		if report == nil {
+			return nil, err
+		}
+		return report, err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runCommand runs a subcommand given after the server flags instead of
+// starting the server, for example
+//
+//	api -db-dsn=$DSN import-discogs -dry-run collection.csv
+func (app *application) runCommand(args []string) error {
+	switch args[0] {
+	case "import-discogs":
+		return app.importDiscogsCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func (app *application) importDiscogsCommand(args []string) error {
+	fs := flag.NewFlagSet("import-discogs", flag.ContinueOnError)
+
+	format := fs.String("format", "", "File format (csv|json); defaults to the file extension")
+	var opts importer.Options
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Report what would be imported without writing anything")
+	fs.BoolVar(&opts.AllowDuplicates, "allow-duplicates", false, "Create albums even when one with the same title and artist exists")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: import-discogs [flags] <collection.csv|releases.json>")
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import-discogs takes exactly one file")
+	}
+
+	path := fs.Arg(0)
+
+	if *format == "" {
+		*format = "csv"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			*format = "json"
+		}
+	}
+
+	if !validator.PermittedValue(*format, "csv", "json") {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	releases, err := readDiscogsReleases(f, *format)
+	if err != nil {
+		return err
+	}
+
+	opts.Progress = func(processed, total int) {
+		app.logger.PrintInfo("importing releases", map[string]string{
+			"processed": fmt.Sprint(processed),
+			"total":     fmt.Sprint(total),
+		})
+	}
+
+	report, importErr := importer.ImportDiscogs(app.models, releases, data.Actor{RequestID: "cli:import-discogs"}, opts)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+
+		err = enc.Encode(envelope{"import": report})
+		if err != nil {
+			return err
+		}
+	}
+
+	return importErr
+}
+
+func (app *application) listAlbumSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Albums.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	sources, err := app.models.AlbumSources.GetAllForAlbum(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sources": sources}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
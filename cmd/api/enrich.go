@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/metadata"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// enrichAlbum looks the album up with the metadata provider and saves what's
+// found. Without a releaseID, an album already linked to the provider is
+// looked up by that link, and any other album by its title and artist.
+func (app *application) enrichAlbum(ctx context.Context, album *data.Album, releaseID string, actor data.Actor) (*data.AlbumSource, []data.Track, error) {
+	provider := app.metadata.Name()
+
+	if releaseID == "" {
+		sources, err := app.models.AlbumSources.GetAllForAlbum(album.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, source := range sources {
+			if source.Source == provider {
+				releaseID = source.ExternalID
+				break
+			}
+		}
+	}
+
+	release, err := app.metadata.Lookup(ctx, metadata.Query{
+		ReleaseID: releaseID,
+		Title:     album.Title,
+		Artist:    album.Artist,
+		Year:      album.Year,
+	})
+	if err != nil {
+		if errors.Is(err, metadata.ErrNoMatch) {
+			markErr := app.models.Albums.MarkEnriched(album.ID)
+			if markErr != nil {
+				return nil, nil, markErr
+			}
+		}
+		return nil, nil, err
+	}
+
+	changed := release.Apply(album)
+	source := release.Source(provider)
+
+	err = app.models.Albums.Enrich(album, changed, source, release.Tracks, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return source, release.Tracks, nil
+}
+
+func (app *application) enrichAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	releaseID := app.readString(r.URL.Query(), "release_id", "")
+
+	v.Check(len(releaseID) <= 100, "release_id", "must not be more than 100 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	album, err := app.models.Albums.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+
+	source, tracks, err := app.enrichAlbum(ctx, album, releaseID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, metadata.ErrNoMatch):
+			app.errorResponse(w, r, http.StatusNotFound, fmt.Sprintf("no matching release was found in %s", app.metadata.Name()))
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, context.DeadlineExceeded):
+			app.errorResponse(w, r, http.StatusGatewayTimeout, fmt.Sprintf("%s took too long to respond -- please try again", app.metadata.Name()))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"album": album, "source": source, "tracks": tracks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAlbumTracksHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Albums.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tracks, err := app.models.Albums.GetTracks(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tracks": tracks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enrichAlbumsJob looks up a batch of albums that haven't been enriched yet.
+// Albums with no match are marked so they aren't tried again; lookups that
+// fail are retried on the next run.
+func (app *application) enrichAlbumsJob() error {
+	albums, err := app.models.Albums.GetUnenriched(app.config.enrich.batchSize)
+	if err != nil {
+		return err
+	}
+
+	enriched := 0
+
+	for _, album := range albums {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, _, err := app.enrichAlbum(ctx, album, "", data.Actor{RequestID: "job:enrich-albums"})
+		cancel()
+
+		switch {
+		case err == nil:
+			enriched++
+		case errors.Is(err, metadata.ErrNoMatch), errors.Is(err, data.ErrEditConflict):
+		default:
+			app.logger.PrintError(err, map[string]string{
+				"job":      "enrich albums",
+				"album_id": fmt.Sprint(album.ID),
+			})
+		}
+	}
+
+	if enriched > 0 {
+		app.logger.PrintInfo("enriched albums", map[string]string{
+			"count": fmt.Sprint(enriched),
+		})
+	}
+
+	return nil
+}
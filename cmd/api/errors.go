@@ -79,4 +79,9 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	msg := "missing required permissions"
 	app.errorResponse(w, r, http.StatusForbidden, msg)
+}
+
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "registration is closed"
+	app.errorResponse(w, r, http.StatusForbidden, msg)
 }
\ No newline at end of file
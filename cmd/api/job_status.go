@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/davemolk/recordAPI/internal/data"
+)
+
+// startJob records a job for the requesting user and runs fn in the
+// background, saving its progress and outcome as it goes.
+func (app *application) startJob(r *http.Request, kind string, total int, fn func(progress func(processed, total int)) (interface{}, error)) (*data.Job, error) {
+	userID := app.contextGetUser(r).ID
+
+	job := &data.Job{UserID: &userID, Kind: kind, Total: total}
+
+	err := app.models.Jobs.Insert(job)
+	if err != nil {
+		return nil, err
+	}
+
+	app.background(func() {
+		app.runJob(job.ID, fn)
+	})
+
+	return job, nil
+}
+
+func (app *application) runJob(id int64, fn func(progress func(processed, total int)) (interface{}, error)) {
+	properties := map[string]string{"job_id": fmt.Sprint(id)}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err := app.models.Jobs.Finish(id, nil, fmt.Errorf("%s", p))
+			if err != nil {
+				app.logger.PrintError(err, properties)
+			}
+			panic(p)
+		}
+	}()
+
+	err := app.models.Jobs.Start(id)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+
+	progress := func(processed, total int) {
+		err := app.models.Jobs.UpdateProgress(id, processed, total)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+	}
+
+	result, jobErr := fn(progress)
+	if jobErr != nil {
+		app.logger.PrintError(jobErr, properties)
+	}
+
+	err = app.models.Jobs.Finish(id, result, jobErr)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// scheduleJobs starts the periodic jobs, which stop once done is closed. A run
+// in progress is allowed to finish, and is waited for like background tasks.
+func (app *application) scheduleJobs(done <-chan struct{}) {
+	if app.config.trash.retention > 0 && app.config.trash.purgeInterval > 0 {
+		app.schedule("purge trash", done, app.config.trash.purgeInterval, app.purgeTrashJob)
+	}
+	if app.config.genres.refreshInterval > 0 {
+		app.schedule("refresh genres", done, app.config.genres.refreshInterval, app.models.Genres.Load)
+	}
+	if app.config.savedSearches.digestInterval > 0 {
+		app.schedule("saved search digest", done, app.config.savedSearches.digestInterval, app.savedSearchDigestJob)
+	}
+	if app.config.loans.reminderInterval > 0 {
+		app.schedule("loan reminders", done, app.config.loans.reminderInterval, app.loanReminderJob)
+	}
+	if app.config.enrich.interval > 0 {
+		app.schedule("enrich albums", done, app.config.enrich.interval, app.enrichAlbumsJob)
+	}
+}
+
+func (app *application) schedule(name string, done <-chan struct{}, interval time.Duration, fn func() error) {
+	run := func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+			}
+		}()
+
+		err := fn()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": name})
+		}
+	}
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+func (app *application) purgeTrashJob() error {
+	purged, err := app.models.Albums.PurgeDeletedBefore(time.Now().Add(-app.config.trash.retention), data.Actor{RequestID: "job:purge-trash"})
+	if err != nil {
+		return err
+	}
+
+	if purged > 0 {
+		app.logger.PrintInfo("purged trashed albums", map[string]string{
+			"count": fmt.Sprint(purged),
+		})
+	}
+
+	return nil
+}
+
+// savedSearchDigestJob emails each subscriber the albums added since their
+// last digest that match their saved search.
+func (app *application) savedSearchDigestJob() error {
+	latestID, err := app.models.Albums.LatestID()
+	if err != nil {
+		return err
+	}
+
+	searches, err := app.models.SavedSearches.GetAllSubscribed()
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+
+	for _, search := range searches {
+		if search.LastAlbumID >= latestID {
+			continue
+		}
+
+		err := app.sendSavedSearchDigest(search, latestID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"job":             "saved search digest",
+				"saved_search_id": fmt.Sprint(search.ID),
+			})
+			continue
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		app.logger.PrintInfo("processed saved search digests", map[string]string{
+			"count": fmt.Sprint(sent),
+		})
+	}
+
+	return nil
+}
+
+func (app *application) sendSavedSearchDigest(search *data.SavedSearch, latestID int64) error {
+	qs, err := url.ParseQuery(search.Query)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	input := app.readAlbumQuery(qs, v)
+	if !v.Valid() {
+		return fmt.Errorf("invalid saved search query: %v", v.Errors)
+	}
+
+	input.UserID = search.UserID
+	input.AfterID = search.LastAlbumID
+	input.Filters.Page = 1
+	input.Filters.PageSize = 50
+	input.Filters.Cursor = ""
+	input.Filters.Count = data.CountExact
+
+	albums, metadata, err := app.models.Albums.GetAll(input.AlbumSearch, input.Filters)
+	if err != nil {
+		return err
+	}
+
+	if len(albums) > 0 {
+		data := map[string]interface{}{
+			"id":     search.ID,
+			"name":   search.Name,
+			"albums": albums,
+			"more":   metadata.TotalRecords - len(albums),
+		}
+
+		err = app.mailer.Send(search.Email, "saved_search_digest.tmpl", data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.models.SavedSearches.MarkNotified(search.ID, latestID)
+}
+
+// loanReminderJob emails the owner of each overdue loan, and the borrower if
+// they're a registered user, at most once every loans.remindEvery.
+func (app *application) loanReminderJob() error {
+	loans, err := app.models.Loans.GetOverdueUnreminded(time.Now().Add(-app.config.loans.remindEvery), 100)
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+
+	for _, loan := range loans {
+		err := app.sendLoanReminders(loan)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"job":     "loan reminders",
+				"loan_id": fmt.Sprint(loan.ID),
+			})
+			continue
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		app.logger.PrintInfo("sent loan reminders", map[string]string{
+			"count": fmt.Sprint(sent),
+		})
+	}
+
+	return nil
+}
+
+func (app *application) sendLoanReminders(loan *data.OverdueLoan) error {
+	data := map[string]interface{}{
+		"id":           loan.ID,
+		"ownerName":    loan.OwnerName,
+		"borrowerName": loan.BorrowerName,
+		"album":        loan.Album,
+		"loanedAt":     loan.LoanedAt.Format("2 January 2006"),
+		"dueAt":        loan.DueAt.Format("2 January 2006"),
+		"daysOverdue":  int(time.Since(*loan.DueAt).Hours() / 24),
+	}
+
+	err := app.mailer.Send(loan.OwnerEmail, "loan_overdue_owner.tmpl", data)
+	if err != nil {
+		return err
+	}
+
+	// The owner has been told, so the loan counts as reminded even if the
+	// borrower's email fails; retrying would send the owner a duplicate.
+	err = app.models.Loans.MarkReminded(loan.ID)
+	if err != nil {
+		return err
+	}
+
+	if loan.BorrowerEmail != "" {
+		err = app.mailer.Send(loan.BorrowerEmail, "loan_overdue_borrower.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"job":     "loan reminders",
+				"loan_id": fmt.Sprint(loan.ID),
+				"to":      "borrower",
+			})
+		}
+	}
+
+	return nil
+}
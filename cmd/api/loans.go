@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// setBorrower links the loan to the registered user with the given email
+// address, or unlinks it if email is empty. A linked borrower's name is used
+// if the loan doesn't have one.
+func (app *application) setBorrower(loan *data.Loan, email string, v *validator.Validator) error {
+	if email == "" {
+		loan.BorrowerID = nil
+		return nil
+	}
+
+	borrower, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("borrower_email", "must belong to a registered user")
+			return nil
+		default:
+			return err
+		}
+	}
+
+	v.Check(borrower.ID != loan.UserID, "borrower_email", "must not be your own email address")
+
+	loan.BorrowerID = &borrower.ID
+	if loan.BorrowerName == "" {
+		loan.BorrowerName = borrower.Name
+	}
+
+	return nil
+}
+
+func (app *application) createLoanHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CopyID          int64      `json:"copy_id"`
+		BorrowerName    string     `json:"borrower_name"`
+		BorrowerContact string     `json:"borrower_contact"`
+		BorrowerEmail   string     `json:"borrower_email"`
+		LoanedAt        *time.Time `json:"loaned_at"`
+		DueAt           *time.Time `json:"due_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	loan := &data.Loan{
+		CopyID:          input.CopyID,
+		UserID:          app.contextGetUser(r).ID,
+		BorrowerName:    input.BorrowerName,
+		BorrowerContact: input.BorrowerContact,
+		LoanedAt:        time.Now(),
+		DueAt:           input.DueAt,
+	}
+
+	if input.LoanedAt != nil {
+		loan.LoanedAt = *input.LoanedAt
+	}
+
+	v := validator.New()
+
+	err = app.setBorrower(loan, input.BorrowerEmail, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateLoan(v, loan); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Loans.Insert(loan, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("copy_id", "must be a copy in your collection")
+			app.failedValidationsResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCopyOnLoan):
+			v.AddError("copy_id", "this copy is already on loan")
+			app.failedValidationsResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the loan back so the response includes its album.
+	loan, err = app.models.Loans.Get(loan.ID, loan.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/loans/%d", loan.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"loan": loan}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readLoanQuery reads the status and pagination parameters shared by the loan
+// listings.
+func (app *application) readLoanQuery(r *http.Request, v *validator.Validator) (string, data.Filters) {
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "all")
+
+	var filters data.Filters
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-loaned_at")
+	filters.SortSafelist = []string{"loaned_at", "due_at", "-loaned_at", "-due_at"}
+
+	v.Check(validator.PermittedValue(status, data.LoanStatusSafelist...), "status", "must be all, open, overdue or returned")
+	data.ValidateFilters(v, filters)
+
+	return status, filters
+}
+
+func (app *application) listLoansHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	status, filters := app.readLoanQuery(r, v)
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	loans, metadata, err := app.models.Loans.GetAllForUser(app.contextGetUser(r).ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loans": loans, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listBorrowedHandler lists the loans made to the user by others.
+func (app *application) listBorrowedHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	status, filters := app.readLoanQuery(r, v)
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	loans, metadata, err := app.models.Loans.GetAllForBorrower(app.contextGetUser(r).ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loans": loans, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLoanHandler(w http.ResponseWriter, r *http.Request) {
+	loan, ok := app.loanForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateLoanHandler(w http.ResponseWriter, r *http.Request) {
+	loan, ok := app.loanForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BorrowerName    *string    `json:"borrower_name"`
+		BorrowerContact *string    `json:"borrower_contact"`
+		BorrowerEmail   *string    `json:"borrower_email"`
+		LoanedAt        *time.Time `json:"loaned_at"`
+		DueAt           *time.Time `json:"due_at"`
+		ReturnedAt      *time.Time `json:"returned_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.BorrowerName != nil {
+		loan.BorrowerName = *input.BorrowerName
+	}
+
+	if input.BorrowerContact != nil {
+		loan.BorrowerContact = *input.BorrowerContact
+	}
+
+	if input.BorrowerEmail != nil {
+		err = app.setBorrower(loan, *input.BorrowerEmail, v)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if input.LoanedAt != nil {
+		loan.LoanedAt = *input.LoanedAt
+	}
+
+	if input.DueAt != nil {
+		loan.DueAt = input.DueAt
+	}
+
+	if input.ReturnedAt != nil {
+		loan.ReturnedAt = input.ReturnedAt
+	}
+
+	if data.ValidateLoan(v, loan); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Loans.Update(loan, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteLoanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Loans.Delete(id, app.contextGetUser(r).ID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "loan successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) loanForRequest(w http.ResponseWriter, r *http.Request) (*data.Loan, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	loan, err := app.models.Loans.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return loan, true
+}
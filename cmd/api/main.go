@@ -4,15 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
+	"math"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/davemolk/recordAPI/internal/cache"
 	"github.com/davemolk/recordAPI/internal/data"
 	"github.com/davemolk/recordAPI/internal/jsonlog"
 	"github.com/davemolk/recordAPI/internal/mailer"
+	"github.com/davemolk/recordAPI/internal/metadata"
+	"github.com/davemolk/recordAPI/internal/validator"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const version = "1.0.0"
@@ -31,6 +37,61 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	password struct {
+		hasher            string
+		bcryptCost        int
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+		minEntropy        float64
+		blocklist         string
+		breachDir         string
+		breachURL         string
+	}
+	cursorSecret string
+	search       struct {
+		config string
+	}
+	registration struct {
+		mode string
+	}
+	autocomplete struct {
+		cacheSize int
+		cacheTTL  time.Duration
+	}
+	savedSearches struct {
+		digestInterval time.Duration
+	}
+	batch struct {
+		maxOperations int
+	}
+	plays struct {
+		maxBatch int
+	}
+	loans struct {
+		reminderInterval time.Duration
+		remindEvery      time.Duration
+	}
+	prices struct {
+		baseCurrency string
+	}
+	imports struct {
+		maxBytes int64
+		maxRows  int
+	}
+	genres struct {
+		refreshInterval time.Duration
+	}
+	enrich struct {
+		interval       time.Duration
+		batchSize      int
+		musicBrainzURL string
+		userAgent      string
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -41,11 +102,14 @@ type config struct {
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config         config
+	logger         *jsonlog.Logger
+	models         data.Models
+	mailer         mailer.Mailer
+	passwordPolicy *validator.PasswordPolicy
+	autocomplete   *cache.LRU[string, []data.FacetCount]
+	metadata       metadata.Provider
+	wg             sync.WaitGroup
 }
 
 func openDB(cfg config) (*sql.DB, error) {
@@ -73,6 +137,67 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
+func configurePasswordHashing(cfg config) error {
+	switch {
+	case cfg.password.bcryptCost < bcrypt.MinCost || cfg.password.bcryptCost > bcrypt.MaxCost:
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	case cfg.password.argon2Memory < 8*1024 || cfg.password.argon2Memory > math.MaxUint32:
+		return fmt.Errorf("argon2id memory must be between 8192 and %d KiB", uint32(math.MaxUint32))
+	case cfg.password.argon2Iterations < 1 || cfg.password.argon2Iterations > math.MaxUint32:
+		return fmt.Errorf("argon2id iterations must be between 1 and %d", uint32(math.MaxUint32))
+	case cfg.password.argon2Parallelism < 1 || cfg.password.argon2Parallelism > math.MaxUint8:
+		return fmt.Errorf("argon2id parallelism must be between 1 and %d", math.MaxUint8)
+	}
+
+	bcryptHasher := data.BcryptHasher{Cost: cfg.password.bcryptCost}
+
+	argon2idHasher := data.DefaultArgon2idHasher
+	argon2idHasher.Memory = uint32(cfg.password.argon2Memory)
+	argon2idHasher.Iterations = uint32(cfg.password.argon2Iterations)
+	argon2idHasher.Parallelism = uint8(cfg.password.argon2Parallelism)
+
+	switch cfg.password.hasher {
+	case "argon2id":
+		data.SetPasswordHasher(argon2idHasher, bcryptHasher)
+	case "bcrypt":
+		data.SetPasswordHasher(bcryptHasher, argon2idHasher)
+	default:
+		return fmt.Errorf("unknown password hasher %q", cfg.password.hasher)
+	}
+	return nil
+}
+
+func newPasswordPolicy(cfg config) (*validator.PasswordPolicy, error) {
+	var breaches validator.BreachSource
+
+	switch {
+	case cfg.password.breachDir != "":
+		breaches = validator.FileBreachSource{Dir: cfg.password.breachDir}
+	case cfg.password.breachURL != "":
+		breaches = validator.HTTPBreachSource{BaseURL: cfg.password.breachURL}
+	}
+
+	policy, err := validator.NewPasswordPolicy(cfg.password.minEntropy, breaches)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.password.blocklist != "" {
+		f, err := os.Open(cfg.password.blocklist)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		err = policy.AddBlocklist(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
 func main() {
 	var cfg config
 
@@ -95,6 +220,47 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.StringVar(&cfg.password.hasher, "password-hasher", "argon2id", "Password hashing algorithm for new hashes (argon2id|bcrypt)")
+	flag.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", 12, "bcrypt cost")
+	flag.UintVar(&cfg.password.argon2Memory, "password-argon2-memory", 64*1024, "Argon2id memory in KiB")
+	flag.UintVar(&cfg.password.argon2Iterations, "password-argon2-iterations", 3, "Argon2id iterations")
+	flag.UintVar(&cfg.password.argon2Parallelism, "password-argon2-parallelism", 2, "Argon2id parallelism")
+	flag.Float64Var(&cfg.password.minEntropy, "password-min-entropy", 40, "Minimum estimated password entropy in bits")
+	flag.StringVar(&cfg.password.blocklist, "password-blocklist", "", "File of additional blocked passwords, one per line")
+	flag.StringVar(&cfg.password.breachDir, "password-breach-dir", "", "Directory of breached password hash ranges (<PREFIX>.txt files)")
+	flag.StringVar(&cfg.password.breachURL, "password-breach-url", "", "Breached password range API URL (e.g. https://api.pwnedpasswords.com/range)")
+
+	flag.StringVar(&cfg.cursorSecret, "cursor-secret", os.Getenv("CURSOR_SECRET"), "Secret used to sign pagination cursors")
+	flag.StringVar(&cfg.search.config, "search-config", "english", "Postgres text search configuration used to index and query albums (e.g. english, simple)")
+	flag.StringVar(&cfg.registration.mode, "registration-mode", "open", "User registration mode (open|invite-only|closed)")
+
+	flag.IntVar(&cfg.autocomplete.cacheSize, "autocomplete-cache-size", 1000, "Maximum number of cached autocomplete results")
+	flag.DurationVar(&cfg.autocomplete.cacheTTL, "autocomplete-cache-ttl", time.Minute, "How long autocomplete results are cached")
+
+	flag.DurationVar(&cfg.savedSearches.digestInterval, "saved-search-digest-interval", 24*time.Hour, "How often to email saved search digests (0 disables digests)")
+
+	flag.IntVar(&cfg.batch.maxOperations, "batch-max-operations", 100, "Maximum number of operations in an album batch request")
+
+	flag.IntVar(&cfg.plays.maxBatch, "plays-max-batch", 500, "Maximum number of plays submitted in one request")
+
+	flag.DurationVar(&cfg.loans.reminderInterval, "loan-reminder-interval", time.Hour, "How often to check for overdue loans to send reminders about (0 disables reminders)")
+	flag.DurationVar(&cfg.loans.remindEvery, "loan-remind-every", 7*24*time.Hour, "How long to wait before reminding about the same overdue loan again")
+
+	flag.StringVar(&cfg.prices.baseCurrency, "base-currency", "USD", "Currency that exchange rates are given in and valuations default to")
+
+	flag.Int64Var(&cfg.imports.maxBytes, "import-max-bytes", 10<<20, "Maximum size in bytes of an album import file")
+	flag.IntVar(&cfg.imports.maxRows, "import-max-rows", 10000, "Maximum number of albums in an import file")
+
+	flag.DurationVar(&cfg.genres.refreshInterval, "genre-refresh-interval", 5*time.Minute, "How often to reload the genre taxonomy from the database")
+
+	flag.DurationVar(&cfg.enrich.interval, "enrich-interval", 0, "How often to look up metadata for albums that haven't been enriched (0 disables the worker)")
+	flag.IntVar(&cfg.enrich.batchSize, "enrich-batch-size", 25, "Maximum number of albums looked up per enrichment run")
+	flag.StringVar(&cfg.enrich.musicBrainzURL, "musicbrainz-url", metadata.MusicBrainzURL, "MusicBrainz web service URL")
+	flag.StringVar(&cfg.enrich.userAgent, "musicbrainz-user-agent", "recordAPI/"+version+" ( no-reply@recordAPI.net )", "User-Agent sent to MusicBrainz, which should include contact details")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted albums stay in the trash before being purged (0 disables purging)")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often to purge expired albums from the trash")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP-USERNAME"), "smtp username")
@@ -103,6 +269,28 @@ func main() {
 
 	flag.Parse()
 
+	if !validator.PermittedValue(cfg.registration.mode, "open", "invite-only", "closed") {
+		logger.PrintFatal(fmt.Errorf("unknown registration mode %q", cfg.registration.mode), nil)
+	}
+
+	if !validator.Matches(cfg.prices.baseCurrency, validator.CurrencyRX) {
+		logger.PrintFatal(fmt.Errorf("base currency %q is not a three-letter ISO 4217 code", cfg.prices.baseCurrency), nil)
+	}
+
+	if cfg.cursorSecret != "" {
+		data.SetCursorKey([]byte(cfg.cursorSecret))
+	}
+
+	err = configurePasswordHashing(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -112,10 +300,31 @@ func main() {
 	logger.PrintInfo("database connection established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:         cfg,
+		logger:         logger,
+		models:         data.NewModels(db),
+		passwordPolicy: passwordPolicy,
+		autocomplete:   cache.NewLRU[string, []data.FacetCount](cfg.autocomplete.cacheSize, cfg.autocomplete.cacheTTL),
+		metadata:       metadata.NewMusicBrainz(cfg.enrich.musicBrainzURL, cfg.enrich.userAgent),
+		mailer:         mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+	}
+
+	err = app.models.Genres.Load()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	err = app.models.Albums.ConfigureSearch(cfg.search.config)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if flag.NArg() > 0 {
+		err = app.runCommand(flag.Args())
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
 	}
 
 	err = app.serve()
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+type playInput struct {
+	AlbumID  int64      `json:"album_id"`
+	Track    string     `json:"track"`
+	PlayedAt *time.Time `json:"played_at"`
+	Source   string     `json:"source"`
+}
+
+// play turns the input into a play by the user, defaulting to a play that
+// happened just now through the API.
+func (in playInput) play(userID int64) *data.Play {
+	play := &data.Play{
+		UserID:   userID,
+		AlbumID:  in.AlbumID,
+		Track:    in.Track,
+		PlayedAt: time.Now(),
+		Source:   in.Source,
+	}
+
+	if in.PlayedAt != nil {
+		play.PlayedAt = *in.PlayedAt
+	}
+
+	if play.Source == "" {
+		play.Source = "api"
+	}
+
+	return play
+}
+
+func (app *application) createPlayHandler(w http.ResponseWriter, r *http.Request) {
+	var input playInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	play := input.play(app.contextGetUser(r).ID)
+
+	v := validator.New()
+
+	if data.ValidatePlay(v, play); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Plays.Insert(play)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("album_id", "must be an existing album")
+			app.failedValidationsResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicatePlay):
+			v.AddError("played_at", "this play has already been recorded")
+			app.failedValidationsResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"play": play}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPlaysHandler records plays in bulk for clients that queue them while
+// offline. Invalid plays are reported and the rest are recorded; plays that
+// were already recorded are counted as duplicates.
+func (app *application) createPlaysHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Plays []playInput `json:"plays"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Plays) > 0, "plays", "must contain at least 1 play")
+	v.Check(len(input.Plays) <= app.config.plays.maxBatch, "plays", fmt.Sprintf("must not contain more than %d plays", app.config.plays.maxBatch))
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	type invalidPlay struct {
+		Index  int               `json:"index"`
+		Errors map[string]string `json:"errors"`
+	}
+
+	userID := app.contextGetUser(r).ID
+	plays := make([]*data.Play, len(input.Plays))
+	albumIDs := make([]int64, len(input.Plays))
+
+	for i, in := range input.Plays {
+		plays[i] = in.play(userID)
+		albumIDs[i] = in.AlbumID
+	}
+
+	missing, err := app.models.Albums.Missing(albumIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	invalid := []invalidPlay{}
+	var valid []*data.Play
+
+	for i, play := range plays {
+		v := validator.New()
+
+		data.ValidatePlay(v, play)
+		v.Check(play.AlbumID <= 0 || !missing[play.AlbumID], "album_id", "must be an existing album")
+
+		if !v.Valid() {
+			invalid = append(invalid, invalidPlay{Index: i, Errors: v.Errors})
+			continue
+		}
+
+		valid = append(valid, play)
+	}
+
+	recorded, err := app.models.Plays.InsertMany(valid)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if recorded == 0 {
+		status = http.StatusOK
+	}
+
+	err = app.writeJSON(w, status, envelope{
+		"recorded":   recorded,
+		"duplicates": len(valid) - recorded,
+		"invalid":    invalid,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPlaysHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.PlayFilters
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.AlbumID = int64(app.readInt(qs, "album_id", 0, v))
+	input.From = app.readTime(qs, "from", v)
+	input.To = app.readTime(qs, "to", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-played_at")
+	input.Filters.SortSafelist = []string{"played_at", "-played_at"}
+
+	if input.From != nil && input.To != nil {
+		v.Check(input.From.Before(*input.To), "from", "must be before to")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	plays, metadata, err := app.models.Plays.GetAllForUser(app.contextGetUser(r).ID, input.PlayFilters, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"plays": plays, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) topPlaysHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.PlayFilters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	by := app.readString(qs, "by", "albums")
+	limit := app.readInt(qs, "limit", 10, v)
+	input.From = app.readTime(qs, "from", v)
+	input.To = app.readTime(qs, "to", v)
+
+	v.Check(validator.PermittedValue(by, data.TopPlaysSafelist...), "by", "must be albums, artists or genres")
+	v.Check(limit > 0 && limit <= 100, "limit", "must be between 1 and 100")
+
+	if input.From != nil && input.To != nil {
+		v.Check(input.From.Before(*input.To), "from", "must be before to")
+	}
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	counts, err := app.models.Plays.Top(app.contextGetUser(r).ID, by, input, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{by: counts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) playStreaksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	tz := app.readString(r.URL.Query(), "tz", "UTC")
+
+	location, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		v.AddError("tz", "must be an IANA time zone name, such as Europe/London")
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	streaks, err := app.models.Plays.Streaks(app.contextGetUser(r).ID, tz)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var current, longest *data.Streak
+
+	// A streak is still current if the user hasn't played anything yet today.
+	now := time.Now().In(location)
+	yesterday := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.UTC)
+
+	if len(streaks) > 0 && !streaks[0].End.Before(yesterday) {
+		current = streaks[0]
+	}
+
+	days := 0
+	for _, streak := range streaks {
+		days += streak.Days
+		if longest == nil || streak.Days > longest.Days {
+			longest = streak
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"current": current, "longest": longest, "listening_days": days}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rediscoverHandler lists albums the user hasn't played in a while, a year by
+// default.
+func (app *application) rediscoverHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	days := app.readInt(qs, "days", 365, v)
+	limit := app.readInt(qs, "limit", 20, v)
+
+	v.Check(days > 0 && days <= 36500, "days", "must be between 1 and 36500")
+	v.Check(limit > 0 && limit <= 100, "limit", "must be between 1 and 100")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	albums, err := app.models.Plays.Forgotten(app.contextGetUser(r).ID, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"albums": albums}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/importer"
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) createAlbumPriceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Source     string     `json:"source"`
+		Condition  string     `json:"condition"`
+		Currency   string     `json:"currency"`
+		Amount     float64    `json:"amount"`
+		ObservedAt *time.Time `json:"observed_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	price := &data.Price{
+		AlbumID:    id,
+		Source:     input.Source,
+		Condition:  strings.ToUpper(input.Condition),
+		Currency:   strings.ToUpper(input.Currency),
+		Amount:     input.Amount,
+		ObservedAt: time.Now(),
+	}
+
+	if input.ObservedAt != nil {
+		price.ObservedAt = *input.ObservedAt
+	}
+
+	v := validator.New()
+
+	if data.ValidatePrice(v, price); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Prices.Insert(price, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"price": price}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAlbumPricesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	condition := strings.ToUpper(app.readString(qs, "condition", ""))
+	currency := strings.ToUpper(app.readString(qs, "currency", ""))
+
+	var filters data.Filters
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-observed_at")
+	filters.SortSafelist = []string{"observed_at", "amount", "-observed_at", "-amount"}
+
+	v.Check(condition == "" || validator.PermittedValue(condition, data.CopyConditions...), "condition", "must be one of M, NM, VG+, VG, G+, G, F or P")
+	v.Check(currency == "" || validator.Matches(currency, validator.CurrencyRX), "currency", "must be a three-letter ISO 4217 code, such as USD")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Albums.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	prices, metadata, err := app.models.Prices.GetAllForAlbum(id, condition, currency, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"prices": prices, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importPricesHandler records prices from a CSV file with album_id, source,
+// condition, currency, amount and observed_at columns. Invalid rows are
+// reported and the rest are recorded.
+func (app *application) importPricesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	source := app.readString(qs, "source", "import")
+	dryRun := app.readBool(qs, "dry_run", false, v)
+
+	v.Check(len(source) <= 100, "source", "must not be more than 100 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	rows, err := importer.ReadPriceCSV(r.Body, source)
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			err = fmt.Errorf("body must not be larger than %d bytes", app.config.imports.maxBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(rows) > 0, "file", "must contain at least 1 price")
+	v.Check(len(rows) <= app.config.imports.maxRows, "file", fmt.Sprintf("must not contain more than %d prices", app.config.imports.maxRows))
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	report, err := importer.ImportPrices(app.models, rows, app.actor(r), dryRun)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if dryRun || report.Imported == 0 {
+		status = http.StatusOK
+	}
+
+	err = app.writeJSON(w, status, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePriceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Prices.Delete(id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "price successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// valuationHandler estimates what the user's collection is worth. Each copy
+// is valued at the median of the most recent prices for its album in its
+// condition, observed within the last days (two years by default), converted
+// to the requested currency.
+func (app *application) valuationHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	currency := strings.ToUpper(app.readString(qs, "currency", app.config.prices.baseCurrency))
+	days := app.readInt(qs, "days", 730, v)
+	samples := app.readInt(qs, "samples", 5, v)
+
+	v.Check(days > 0 && days <= 36500, "days", "must be between 1 and 36500")
+	v.Check(samples > 0 && samples <= 50, "samples", "must be between 1 and 50")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	rates, err := app.models.ExchangeRates.Rates(app.config.prices.baseCurrency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if _, ok := rates[currency]; !ok {
+		v.AddError("currency", "must be a currency with an exchange rate")
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	collection, err := app.models.Prices.GetForCollection(app.contextGetUser(r).ID, time.Now().AddDate(0, 0, -days), samples)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"valuation": data.Valuate(collection, rates, currency)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.ExchangeRates.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"base_currency": app.config.prices.baseCurrency, "exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) setExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rate float64 `json:"rate"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rate := &data.ExchangeRate{
+		Currency: strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("currency")),
+		Rate:     input.Rate,
+	}
+
+	v := validator.New()
+
+	data.ValidateExchangeRate(v, rate)
+	v.Check(rate.Currency != app.config.prices.baseCurrency, "currency", "must not be the base currency")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExchangeRates.Set(rate, app.actor(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rate": rate}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("currency"))
+
+	err := app.models.ExchangeRates.Delete(currency, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "exchange rate successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
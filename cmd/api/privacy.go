@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// publicAlbum is the part of an album shown on public and shared collection
+// pages.
+type publicAlbum struct {
+	ID     int64    `json:"id"`
+	Title  string   `json:"title"`
+	Artist string   `json:"artist"`
+	Genres []string `json:"genres,omitempty"`
+	Year   int32    `json:"year,omitempty"`
+}
+
+// listCollection serves the albums in a user's collection to a visitor,
+// using the same parameters as GET /v1/albums apart from tags, which are
+// private to the owner.
+func (app *application) listCollection(w http.ResponseWriter, r *http.Request, userID int64, extra envelope) {
+	v := validator.New()
+
+	input := app.readAlbumQuery(r.URL.Query(), v)
+	input.CollectionUserID = userID
+
+	v.Check(len(input.Tags) == 0, "tags", "must not be used on a shared collection")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	env, err := app.listAlbums(input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	albums := env["albums"].([]*data.Album)
+	public := make([]publicAlbum, len(albums))
+
+	for i, album := range albums {
+		public[i] = publicAlbum{
+			ID:     album.ID,
+			Title:  album.Title,
+			Artist: album.Artist,
+			Genres: album.Genres,
+			Year:   album.Year,
+		}
+	}
+
+	env["albums"] = public
+	for key, value := range extra {
+		env[key] = value
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPublicCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	slug := strings.ToLower(httprouter.ParamsFromContext(r.Context()).ByName("slug"))
+
+	privacy, err := app.models.Privacy.GetPublic(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	profile := map[string]string{"slug": privacy.Slug}
+	if privacy.ShowName {
+		profile["name"] = privacy.Name
+	}
+
+	app.listCollection(w, r, privacy.UserID, envelope{"profile": profile})
+}
+
+func (app *application) showSharedCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.models.ShareLinks.Use(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.listCollection(w, r, userID, nil)
+}
+
+func (app *application) showPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	privacy, err := app.models.Privacy.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"privacy": privacy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	privacy, err := app.models.Privacy.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Slug             *string `json:"slug"`
+		CollectionPublic *bool   `json:"collection_public"`
+		ShowName         *bool   `json:"show_name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Slug != nil {
+		privacy.Slug = strings.ToLower(strings.TrimSpace(*input.Slug))
+	}
+
+	if input.CollectionPublic != nil {
+		privacy.CollectionPublic = *input.CollectionPublic
+	}
+
+	if input.ShowName != nil {
+		privacy.ShowName = *input.ShowName
+	}
+
+	v := validator.New()
+
+	if data.ValidatePrivacy(v, privacy); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Privacy.Update(privacy, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "this slug is already taken")
+			app.failedValidationsResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"privacy": privacy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string     `json:"name"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	link := &data.ShareLink{
+		UserID:    app.contextGetUser(r).ID,
+		Name:      input.Name,
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	v := validator.New()
+
+	if data.ValidateShareLink(v, link); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ShareLinks.New(link, app.actor(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The token can't be recovered later, so the link is only ever shown here.
+	url := fmt.Sprintf("/v1/shared/%s/collection", link.Plaintext)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"share_link": link, "url": url}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listShareLinksHandler(w http.ResponseWriter, r *http.Request) {
+	links, err := app.models.ShareLinks.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"share_links": links}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.ShareLinks.Revoke(id, app.contextGetUser(r).ID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "share link successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
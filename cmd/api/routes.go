@@ -16,14 +16,109 @@ func (app *application) routes() http.Handler {
 	
 	router.HandlerFunc(http.MethodGet, "/v1/albums", app.requirePermission("albums:read", app.listAlbumsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/albums", app.requirePermission("albums:write", app.createAlbumHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/albums/:id", app.requirePermission("albums:read", app.showAlbumHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id", app.albumSubroutes(map[string]http.HandlerFunc{
+		"trash":      app.requirePermission("albums:write", app.listDeletedAlbumsHandler),
+		"export":     app.requirePermission("albums:read", app.exportAlbumsHandler),
+		"duplicates": app.requirePermission("albums:read", app.listDuplicateAlbumsHandler),
+	}, app.requirePermission("albums:read", app.showAlbumHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id", app.albumSubroutes(map[string]http.HandlerFunc{
+		"batch":  app.requirePermission("albums:write", app.batchAlbumsHandler),
+		"import": app.requirePermission("albums:write", app.importAlbumsHandler),
+	}, app.notFoundResponse))
 	router.HandlerFunc(http.MethodPatch, "/v1/albums/:id", app.requirePermission("albums:write", app.updateAlbumHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/albums/:id", app.requirePermission("albums:write", app.deleteAlbumHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/restore", app.requirePermission("albums:write", app.undeleteAlbumHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/albums/:id/purge", app.requirePermission("albums:purge", app.purgeAlbumHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/tags", app.requirePermission("albums:read", app.addAlbumTagsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/albums/:id/tags", app.requirePermission("albums:read", app.removeAlbumTagsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/merge", app.requirePermission("albums:write", app.mergeAlbumHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/enrich", app.requirePermission("albums:write", app.enrichAlbumHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id/tracks", app.requirePermission("albums:read", app.listAlbumTracksHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id/sources", app.requirePermission("albums:read", app.listAlbumSourcesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id/prices", app.requirePermission("albums:read", app.listAlbumPricesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/prices", app.requirePermission("prices:write", app.createAlbumPriceHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id/history", app.requirePermission("albums:read", app.listAlbumRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id/history/:version", app.requirePermission("albums:read", app.showAlbumRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/restore/:version", app.requirePermission("albums:write", app.restoreAlbumRevisionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("albums:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.requirePermission("albums:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("genres:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requirePermission("genres:write", app.deleteGenreHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/prices/import", app.requirePermission("prices:write", app.importPricesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/prices/:id", app.requirePermission("prices:write", app.deletePriceHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/exchange-rates", app.requirePermission("albums:read", app.listExchangeRatesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/exchange-rates/:currency", app.requirePermission("prices:write", app.setExchangeRateHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/exchange-rates/:currency", app.requirePermission("prices:write", app.deleteExchangeRateHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/jobs/:id", app.requireActivatedUser(app.showJobHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/plays", app.requirePermission("albums:read", app.createPlayHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/plays/batch", app.requirePermission("albums:read", app.createPlaysHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/autocomplete", app.requirePermission("albums:read", app.autocompleteHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/plays", app.requirePermission("albums:read", app.listPlaysHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/stats/top", app.requirePermission("albums:read", app.topPlaysHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/stats/streaks", app.requirePermission("albums:read", app.playStreaksHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/rediscover", app.requirePermission("albums:read", app.rediscoverHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/copies", app.requirePermission("albums:read", app.listCopiesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/copies", app.requirePermission("albums:read", app.createCopyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/copies/:id", app.requirePermission("albums:read", app.showCopyHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/copies/:id", app.requirePermission("albums:read", app.updateCopyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/copies/:id", app.requirePermission("albums:read", app.deleteCopyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/loans", app.requirePermission("albums:read", app.listLoansHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/loans", app.requirePermission("albums:read", app.createLoanHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/loans/:id", app.requirePermission("albums:read", app.showLoanHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/loans/:id", app.requirePermission("albums:read", app.updateLoanHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/loans/:id", app.requirePermission("albums:read", app.deleteLoanHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/borrowed", app.requirePermission("albums:read", app.listBorrowedHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/valuation", app.requirePermission("albums:read", app.valuationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/privacy", app.requirePermission("albums:read", app.showPrivacyHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/privacy", app.requirePermission("albums:read", app.updatePrivacyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/share-links", app.requirePermission("albums:read", app.listShareLinksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/share-links", app.requirePermission("albums:read", app.createShareLinkHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/share-links/:id", app.requirePermission("albums:read", app.revokeShareLinkHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/tags", app.requirePermission("albums:read", app.listTagsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/saved-searches", app.requirePermission("albums:read", app.listSavedSearchesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/saved-searches", app.requirePermission("albums:read", app.createSavedSearchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/saved-searches/:id", app.requirePermission("albums:read", app.showSavedSearchHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/saved-searches/:id", app.requirePermission("albums:read", app.updateSavedSearchHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/saved-searches/:id", app.requirePermission("albums:read", app.deleteSavedSearchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/saved-searches/:id/albums", app.requirePermission("albums:read", app.runSavedSearchHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/public/:slug/collection", app.showPublicCollectionHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/shared/:token/collection", app.showSharedCollectionHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/invitations", app.requirePermission("invitations:write", app.createInvitationHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission("audit:read", app.listAuditEntriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/audit/verify", app.requirePermission("audit:read", app.verifyAuditLogHandler))
+
+	return app.recoverPanic(app.requestID(app.rateLimit(app.authenticate(router))))
+}
+
+// albumSubroutes serves the named collections under /v1/albums (such as
+// /v1/albums/trash) from the /v1/albums/:id route, since httprouter doesn't
+// allow a static segment alongside a wildcard. Anything else falls through to
+// next.
+func (app *application) albumSubroutes(named map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := named[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// savedSearchPageParams are taken from the request rather than the saved
+// query when a saved search is run.
+var savedSearchPageParams = []string{"page", "page_size", "cursor", "count"}
+
+// readSavedQuery parses a saved search's query string, checks it with the
+// same rules as GET /v1/albums and returns it re-encoded without any
+// pagination position.
+func (app *application) readSavedQuery(raw string, v *validator.Validator) string {
+	qs, err := url.ParseQuery(strings.TrimPrefix(raw, "?"))
+	if err != nil {
+		v.AddError("query", "must be a valid URL query string")
+		return ""
+	}
+
+	qs.Del("page")
+	qs.Del("cursor")
+
+	qv := validator.New()
+	app.readAlbumQuery(qs, qv)
+
+	for key, message := range qv.Errors {
+		v.AddError("query."+key, message)
+	}
+
+	return qs.Encode()
+}
+
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name       string `json:"name"`
+		Query      string `json:"query"`
+		Subscribed bool   `json:"subscribed"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	search := &data.SavedSearch{
+		UserID:     app.contextGetUser(r).ID,
+		Name:       input.Name,
+		Query:      app.readSavedQuery(input.Query, v),
+		Subscribed: input.Subscribed,
+	}
+
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SavedSearches.Insert(search, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSavedSearch):
+			v.AddError("name", "a saved search with this name already exists")
+			app.failedValidationsResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/saved-searches/%d", search.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"saved_search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	searches, err := app.models.SavedSearches.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_searches": searches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := app.savedSearchForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := app.savedSearchForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name       *string `json:"name"`
+		Query      *string `json:"query"`
+		Subscribed *bool   `json:"subscribed"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Name != nil {
+		search.Name = *input.Name
+	}
+
+	if input.Query != nil {
+		search.Query = app.readSavedQuery(*input.Query, v)
+	}
+
+	if input.Subscribed != nil {
+		search.Subscribed = *input.Subscribed
+	}
+
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SavedSearches.Update(search, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSavedSearch):
+			v.AddError("name", "a saved search with this name already exists")
+			app.failedValidationsResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.SavedSearches.Delete(id, app.contextGetUser(r).ID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "saved search successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) runSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := app.savedSearchForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	qs, err := url.ParseQuery(search.Query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	requested := r.URL.Query()
+	for _, key := range savedSearchPageParams {
+		if requested.Has(key) {
+			qs.Set(key, requested.Get(key))
+		}
+	}
+
+	v := validator.New()
+
+	input := app.readAlbumQuery(qs, v)
+	input.UserID = search.UserID
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	env, err := app.listAlbums(input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env["saved_search"] = search
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) savedSearchForRequest(w http.ResponseWriter, r *http.Request) (*data.SavedSearch, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	search, err := app.models.SavedSearches.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return search, true
+}
@@ -21,6 +21,9 @@ func (app *application) serve() error {
 	}
 
 	shutdownError := make(chan error)
+	stopJobs := make(chan struct{})
+
+	app.scheduleJobs(stopJobs)
 
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -44,6 +47,7 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		close(stopJobs)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
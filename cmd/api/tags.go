@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+func (app *application) addAlbumTagsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeAlbumTags(w, r, app.models.Tags.AddForAlbum)
+}
+
+func (app *application) removeAlbumTagsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeAlbumTags(w, r, app.models.Tags.RemoveFromAlbum)
+}
+
+func (app *application) changeAlbumTags(w http.ResponseWriter, r *http.Request, change func(userID, albumID int64, tags []string, actor data.Actor) ([]string, error)) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Tags []string `json:"tags"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tags := data.NormalizeTags(input.Tags)
+
+	v := validator.New()
+
+	if data.ValidateTags(v, tags); !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	tags, err = change(app.contextGetUser(r).ID, id, tags, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"album_id": id, "tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	limit := app.readInt(r.URL.Query(), "limit", 100, v)
+
+	v.Check(limit > 0, "limit", "must be greater than 0")
+	v.Check(limit <= 500, "limit", "maximum of 500")
+
+	if !v.Valid() {
+		app.failedValidationsResponse(w, r, v.Errors)
+		return
+	}
+
+	tags, err := app.models.Tags.GetCloud(app.contextGetUser(r).ID, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -53,7 +53,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24 * time.Hour, data.ScopeAuthentication)
+	actor := app.actor(r)
+	actor.UserID = user.ID
+
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(user, actor)
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24 * time.Hour, data.ScopeAuthentication, actor)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/davemolk/recordAPI/internal/data"
@@ -10,10 +11,16 @@ import (
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.registration.mode == "closed" {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name            string `json:"name"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		InvitationToken string `json:"invitation_token"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -36,30 +43,49 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
-	if data.ValidateUser(v, user); !v.Valid() {
+	data.ValidateUser(v, user)
+
+	err = app.passwordPolicy.Validate(v, "password", input.Password, user.Name, user.Email)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	var invitation *data.Invitation
+
+	if app.config.registration.mode == "invite-only" && v.Valid() {
+		invitation, err = app.invitationForRegistration(v, input.InvitationToken, user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !v.Valid() {
 		app.failedValidationsResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	permissions := []string{"albums:read"}
+	if invitation != nil {
+		permissions = append(permissions, invitation.Permissions...)
+	}
+
+	err = app.models.Users.Register(user, permissions, invitation, app.actor(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "this email is already in use")
 			app.failedValidationsResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired invitation token")
+			app.failedValidationsResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "albums:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation, app.actor(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -83,6 +109,29 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (app *application) invitationForRegistration(v *validator.Validator, tokenPlaintext, email string) (*data.Invitation, error) {
+	v.Check(tokenPlaintext != "", "invitation_token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "invitation_token", "must be 26 bytes long")
+	if !v.Valid() {
+		return nil, nil
+	}
+
+	invitation, err := app.models.Invitations.GetForToken(tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired invitation token")
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	v.Check(strings.EqualFold(invitation.Email, email), "email", "must match the invited email address")
+
+	return invitation, nil
+}
+
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		TokenPlaintext string `json:"token"`
@@ -115,7 +164,10 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	actor := app.actor(r)
+	actor.UserID = user.ID
+
+	err = app.models.Users.Update(user, actor)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -126,7 +178,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID, actor)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
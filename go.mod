@@ -1,6 +1,6 @@
 module github.com/davemolk/recordAPI
 
-go 1.18
+go 1.20
 
 require (
 	github.com/joho/godotenv v1.4.0
@@ -10,9 +10,12 @@ require (
 
 require golang.org/x/time v0.0.0-20220609170525-579cf78fd858
 
-require golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+require (
+	github.com/go-mail/mail/v2 v2.3.0
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+)
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+var BatchOperationSafelist = []string{BatchCreate, BatchUpdate, BatchDelete}
+
+// BatchOperation is one write in an album batch. Album is the album to
+// create, the updated album at the version it was read, or for a delete just
+// its ID. Once the batch has run, Album holds the result and Err the reason
+// the operation failed, if it did.
+type BatchOperation struct {
+	Op    string
+	Album *Album
+	Err   error
+}
+
+// Batch applies the operations in order. When atomic is set they share a
+// transaction, and the first failure rolls back the whole batch and is
+// returned. Otherwise each operation commits or fails on its own and Batch
+// only returns an error if it couldn't run at all.
+func (a AlbumModel) Batch(ops []*BatchOperation, atomic bool, actor Actor) error {
+	if !atomic {
+		for _, op := range ops {
+			op.Err = a.batchOperation(op, actor)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, op := range ops {
+		op.Err = applyBatchOperation(ctx, tx, op, actor)
+		if op.Err != nil {
+			return op.Err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (a AlbumModel) batchOperation(op *BatchOperation, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = applyBatchOperation(ctx, tx, op, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func applyBatchOperation(ctx context.Context, tx *sql.Tx, op *BatchOperation, actor Actor) error {
+	switch op.Op {
+	case BatchCreate:
+		return insertAlbum(ctx, tx, op.Album, actor)
+	case BatchUpdate:
+		return updateAlbum(ctx, tx, op.Album, actor, RevisionUpdate, nil)
+	case BatchDelete:
+		album, err := setAlbumDeleted(ctx, tx, deleteAlbumQuery, op.Album.ID, actor, RevisionDelete)
+		if err != nil {
+			return err
+		}
+		op.Album = album
+		return nil
+	default:
+		return fmt.Errorf("unknown batch operation %q", op.Op)
+	}
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RevisionCreate   = "create"
+	RevisionUpdate   = "update"
+	RevisionRestore  = "restore"
+	RevisionDelete   = "delete"
+	RevisionUndelete = "undelete"
+	RevisionEnrich   = "enrich"
+	RevisionMerge    = "merge"
+)
+
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type AlbumRevision struct {
+	AlbumID      int64                  `json:"album_id"`
+	Version      int32                  `json:"version"`
+	UserID       *int64                 `json:"user_id"`
+	CreatedAt    time.Time              `json:"created_at"`
+	Action       string                 `json:"action"`
+	RestoredFrom *int32                 `json:"restored_from,omitempty"`
+	Title        string                 `json:"title"`
+	Artist       string                 `json:"artist"`
+	Genres       []string               `json:"genres"`
+	Year         int32                  `json:"year,omitempty"`
+	Changes      map[string]FieldChange `json:"changes"`
+}
+
+func newAlbumRevision(album, old *Album, userID int64, action string) *AlbumRevision {
+	revision := &AlbumRevision{
+		AlbumID: album.ID,
+		Version: album.Version,
+		Action:  action,
+		Title:   album.Title,
+		Artist:  album.Artist,
+		Genres:  album.Genres,
+		Year:    album.Year,
+		Changes: albumChanges(old, album),
+	}
+
+	if userID > 0 {
+		revision.UserID = &userID
+	}
+
+	return revision
+}
+
+func albumChanges(old, new *Album) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old == nil {
+		old = &Album{}
+	}
+
+	if old.Title != new.Title {
+		changes["title"] = FieldChange{Old: nilIfEmpty(old.Title), New: new.Title}
+	}
+	if old.Artist != new.Artist {
+		changes["artist"] = FieldChange{Old: nilIfEmpty(old.Artist), New: new.Artist}
+	}
+	if !equalStrings(old.Genres, new.Genres) {
+		changes["genres"] = FieldChange{Old: old.Genres, New: new.Genres}
+	}
+	if old.Year != new.Year {
+		changes["year"] = FieldChange{Old: nilIfZero(old.Year), New: nilIfZero(new.Year)}
+	}
+
+	return changes
+}
+
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nilIfZero(n int32) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeAlbumRevision(ctx context.Context, tx *sql.Tx, revision *AlbumRevision, actor Actor) error {
+	err := insertAlbumRevision(ctx, tx, revision)
+	if err != nil {
+		return err
+	}
+
+	return writeAudit(ctx, tx, actor, "album."+revision.Action, "album", revision.AlbumID, revision.Changes)
+}
+
+func insertAlbumRevision(ctx context.Context, tx *sql.Tx, revision *AlbumRevision) error {
+	changes, err := json.Marshal(revision.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO album_revisions (album_id, version, user_id, action, restored_from, title, artist, genres, year, changes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10)
+		RETURNING created_at`
+
+	args := []interface{}{
+		revision.AlbumID,
+		revision.Version,
+		revision.UserID,
+		revision.Action,
+		revision.RestoredFrom,
+		revision.Title,
+		revision.Artist,
+		pq.Array(revision.Genres),
+		revision.Year,
+		changes,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&revision.CreatedAt)
+}
+
+type AlbumRevisionModel struct {
+	DB *sql.DB
+}
+
+func (m AlbumRevisionModel) Get(albumID int64, version int32) (*AlbumRevision, error) {
+	if albumID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT album_id, version, user_id, created_at, action, restored_from, title, artist, genres, COALESCE(year, 0), changes
+		FROM album_revisions
+		WHERE album_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	revision, err := scanAlbumRevision(m.DB.QueryRowContext(ctx, query, albumID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return revision, nil
+}
+
+func (m AlbumRevisionModel) GetAllForAlbum(albumID int64, filters Filters) ([]*AlbumRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), album_id, version, user_id, created_at, action, restored_from, title, artist, genres, COALESCE(year, 0), changes
+		FROM album_revisions
+		WHERE album_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, albumID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*AlbumRevision{}
+
+	for rows.Next() {
+		revision, err := scanAlbumRevision(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAlbumRevision(row scanner, extra ...interface{}) (*AlbumRevision, error) {
+	var revision AlbumRevision
+	var changes []byte
+
+	dest := append(extra,
+		&revision.AlbumID,
+		&revision.Version,
+		&revision.UserID,
+		&revision.CreatedAt,
+		&revision.Action,
+		&revision.RestoredFrom,
+		&revision.Title,
+		&revision.Artist,
+		pq.Array(&revision.Genres),
+		&revision.Year,
+		&changes,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(changes, &revision.Changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AlbumSource links an album to a record in an external catalogue, such as a
+// Discogs release, along with details from that record that albums don't
+// have fields for (label, format and so on).
+type AlbumSource struct {
+	AlbumID    int64             `json:"album_id"`
+	Source     string            `json:"source"`
+	ExternalID string            `json:"external_id"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type AlbumSourceModel struct {
+	DB *sql.DB
+}
+
+// InsertAlbum creates an album and links it to its source in one
+// transaction.
+func (m AlbumSourceModel) InsertAlbum(album *Album, source *AlbumSource, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAlbum(ctx, tx, album, actor)
+	if err != nil {
+		return err
+	}
+
+	source.AlbumID = album.ID
+
+	err = insertAlbumSource(ctx, tx, source, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Link records that an existing album corresponds to an external record.
+func (m AlbumSourceModel) Link(source *AlbumSource, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAlbumSource(ctx, tx, source, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertAlbumSource links an album to an external record, moving the link if
+// the record was already linked to another album.
+func insertAlbumSource(ctx context.Context, tx *sql.Tx, source *AlbumSource, actor Actor) error {
+	if source.Metadata == nil {
+		source.Metadata = map[string]string{}
+	}
+
+	metadata, err := json.Marshal(source.Metadata)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO album_sources (source, external_id, album_id, metadata)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (source, external_id) DO UPDATE
+		SET album_id = EXCLUDED.album_id, metadata = EXCLUDED.metadata
+		RETURNING created_at`
+
+	err = tx.QueryRowContext(ctx, query, source.Source, source.ExternalID, source.AlbumID, metadata).Scan(&source.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return writeAudit(ctx, tx, actor, "album.link", "album", source.AlbumID, map[string]FieldChange{
+		source.Source: {Old: nil, New: source.ExternalID},
+	})
+}
+
+// FindAlbums returns the live albums linked to the given external records,
+// keyed by external ID.
+func (m AlbumSourceModel) FindAlbums(source string, externalIDs []string) (map[string]int64, error) {
+	query := `
+		SELECT s.external_id, s.album_id
+		FROM album_sources s
+		INNER JOIN albums a ON a.id = s.album_id
+		WHERE s.source = $1 AND s.external_id = ANY($2) AND a.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, source, pq.Array(externalIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := map[string]int64{}
+
+	for rows.Next() {
+		var externalID string
+		var albumID int64
+		err := rows.Scan(&externalID, &albumID)
+		if err != nil {
+			return nil, err
+		}
+		albums[externalID] = albumID
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+func (m AlbumSourceModel) GetAllForAlbum(albumID int64) ([]*AlbumSource, error) {
+	query := `
+		SELECT album_id, source, external_id, metadata, created_at
+		FROM album_sources
+		WHERE album_id = $1
+		ORDER BY source, external_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []*AlbumSource{}
+
+	for rows.Next() {
+		var source AlbumSource
+		var metadata []byte
+
+		err := rows.Scan(&source.AlbumID, &source.Source, &source.ExternalID, &metadata, &source.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(metadata, &source.Metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, &source)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sources, nil
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/davemolk/recordAPI/internal/validator"
@@ -12,12 +14,15 @@ import (
 )
 
 type Album struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Artist    string    `json:"artist"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID        int64        `json:"id"`
+	CreatedAt time.Time    `json:"-"`
+	Title     string       `json:"title"`
+	Artist    string       `json:"artist"`
+	Genres    []string     `json:"genres,omitempty"`
+	Year      int32        `json:"year,omitempty"`
+	Version   int32        `json:"version"`
+	DeletedAt *time.Time   `json:"deleted_at,omitempty"`
+	Search    *SearchMatch `json:"search,omitempty"`
 }
 
 type AlbumModel struct {
@@ -30,20 +35,51 @@ func ValidateAlbum(v *validator.Validator, album *Album) {
 	v.Check(album.Genres != nil, "genre", "genre required")
 	v.Check(validator.Unique(album.Genres), "genres", "no duplicate values")
 
+	if album.Genres != nil {
+		var unknown []string
+		album.Genres, unknown = NormalizeGenres(album.Genres)
+		for _, genre := range unknown {
+			v.AddError("genres", fmt.Sprintf("%q is not a known genre", genre))
+		}
+	}
+
+	v.Check(album.Year == 0 || album.Year >= 1877, "year", "must be 1877 or later")
+	v.Check(album.Year <= int32(time.Now().Year()+1), "year", "must not be in the future")
+
 }
 
-func (a AlbumModel) Insert(album *Album) error {
+func (a AlbumModel) Insert(album *Album, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAlbum(ctx, tx, album, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertAlbum(ctx context.Context, tx *sql.Tx, album *Album, actor Actor) error {
 	query := `
-		INSERT INTO albums (title, artist, genres)
-		VALUES ($1, $2, $3)
+		INSERT INTO albums (title, artist, genres, year)
+		VALUES ($1, $2, $3, NULLIF($4, 0))
 		RETURNING id, created_at, version`
 
-	args := []interface{}{album.Title, album.Artist, pq.Array(album.Genres)}
+	args := []interface{}{album.Title, album.Artist, pq.Array(album.Genres), album.Year}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&album.ID, &album.CreatedAt, &album.Version)
+	if err != nil {
+		return err
+	}
 
-	return a.DB.QueryRowContext(ctx, query, args...).Scan(&album.ID, &album.CreatedAt, &album.Version)
+	return writeAlbumRevision(ctx, tx, newAlbumRevision(album, nil, actor.UserID, RevisionCreate), actor)
 }
 
 func (a AlbumModel) Get(id int64) (*Album, error) {
@@ -52,9 +88,9 @@ func (a AlbumModel) Get(id int64) (*Album, error) {
 	}
 
 	query := `
-		SELECT id, created_at, title, artist, genres, version
+		SELECT id, created_at, title, artist, genres, COALESCE(year, 0), version
 		FROM albums
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	var album Album
 
@@ -67,6 +103,7 @@ func (a AlbumModel) Get(id int64) (*Album, error) {
 		&album.Title,
 		&album.Artist,
 		pq.Array(&album.Genres),
+		&album.Year,
 		&album.Version,
 	)
 
@@ -83,20 +120,271 @@ func (a AlbumModel) Get(id int64) (*Album, error) {
 
 }
 
-func (a AlbumModel) GetAll(title, artist string, genres []string, filters Filters) ([]*Album, Metadata, error) {
+// GetBatch returns up to limit albums matching search in ID order, starting
+// after search.AfterID, for walking through every match a batch at a time.
+func (a AlbumModel) GetBatch(search AlbumSearch, limit int) ([]*Album, error) {
+	args := queryArgs{}
+	where := albumWhere(&args, search)
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, artist, genres, version
+		SELECT id, created_at, title, artist, genres, COALESCE(year, 0), version
 		FROM albums
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-		AND (to_tsvector('simple', artist) @@ plainto_tsquery('simple', $2) OR $2 = '') 
-		AND (genres @> $3 OR $3 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+		%s
+		ORDER BY id ASC
+		LIMIT %s`, where, args.add(limit))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []*Album{}
+
+	for rows.Next() {
+		var album Album
+		err := rows.Scan(
+			&album.ID,
+			&album.CreatedAt,
+			&album.Title,
+			&album.Artist,
+			pq.Array(&album.Genres),
+			&album.Year,
+			&album.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, &album)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+// FindExisting looks up live albums with the same title and artist (ignoring
+// case) as each of the given albums, and returns the ID of the match for each
+// index that has one.
+func (a AlbumModel) FindExisting(albums []*Album) (map[int]int64, error) {
+	titles := make([]string, len(albums))
+	artists := make([]string, len(albums))
+	for i, album := range albums {
+		titles[i] = album.Title
+		artists[i] = album.Artist
+	}
+
+	query := `
+		SELECT i.position, min(a.id)
+		FROM unnest($1::text[], $2::text[]) WITH ORDINALITY AS i(title, artist, position)
+		INNER JOIN albums a ON lower(a.title) = lower(i.title) AND lower(a.artist) = lower(i.artist)
+		WHERE a.deleted_at IS NULL
+		GROUP BY i.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, pq.Array(titles), pq.Array(artists))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[int]int64{}
+
+	for rows.Next() {
+		var position int
+		var id int64
+		err := rows.Scan(&position, &id)
+		if err != nil {
+			return nil, err
+		}
+		existing[position-1] = id
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+// LatestID returns the ID of the most recently added album, including any
+// that have since been deleted.
+func (a AlbumModel) LatestID() (int64, error) {
+	query := `SELECT COALESCE(max(id), 0) FROM albums`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, artist, pq.Array(genres), filters.limit(), filters.offset()}
+	var id int64
+	err := a.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+type AlbumSearch struct {
+	Query  string
+	Title  string
+	Artist string
+	Genres []string
+	Decade int
+	Fuzzy  bool
+	Filter FilterExpr
+	// Subgenres widens each genre in Genres to also match its descendants.
+	Subgenres bool
+	// Tags matches albums carrying all of the given tags, as applied by
+	// UserID.
+	Tags   []string
+	UserID int64
+	// AfterID restricts results to albums added after the given ID.
+	AfterID int64
+	// CollectionUserID restricts results to albums with a copy in the given
+	// user's collection.
+	CollectionUserID int64
+}
+
+func (s AlbumSearch) HasText() bool {
+	return s.Query != "" || s.Title != "" || s.Artist != ""
+}
+
+// HasQuery reports whether the search's free text has any words to search
+// for, and so whether its results can be ranked by relevance.
+func (s AlbumSearch) HasQuery() bool {
+	return prefixTSQuery(s.Query) != ""
+}
+
+// albumWhere builds the WHERE clause for an album search. Criteria named in
+// exclude are left out, so a facet can be counted against every filter but
+// its own.
+func albumWhere(args *queryArgs, search AlbumSearch, exclude ...string) string {
+	conditions := []string{"deleted_at IS NULL"}
+
+	if search.Fuzzy {
+		if search.Query != "" && !validator.PermittedValue("q", exclude...) {
+			conditions = append(conditions, fmt.Sprintf("(%[1]s <%% lower(title) OR %[1]s <%% lower(artist))", args.add(strings.ToLower(search.Query))))
+		}
+		if search.Title != "" && !validator.PermittedValue("title", exclude...) {
+			conditions = append(conditions, fmt.Sprintf("%s <%% lower(title)", args.add(strings.ToLower(search.Title))))
+		}
+		if search.Artist != "" && !validator.PermittedValue("artist", exclude...) {
+			conditions = append(conditions, fmt.Sprintf("%s <%% lower(artist)", args.add(strings.ToLower(search.Artist))))
+		}
+	} else {
+		if tsq := prefixTSQuery(search.Query); tsq != "" && !validator.PermittedValue("q", exclude...) {
+			conditions = append(conditions, fmt.Sprintf("search_vector @@ %s", tsQuery(args.add(tsq))))
+		}
+		if search.Title != "" && !validator.PermittedValue("title", exclude...) {
+			conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', title) @@ plainto_tsquery('simple', %s)", args.add(search.Title)))
+		}
+		if search.Artist != "" && !validator.PermittedValue("artist", exclude...) {
+			conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', artist) @@ plainto_tsquery('simple', %s)", args.add(search.Artist)))
+		}
+	}
+	if len(search.Genres) > 0 && !validator.PermittedValue("genres", exclude...) {
+		if search.Subgenres {
+			for _, genre := range search.Genres {
+				conditions = append(conditions, fmt.Sprintf("genres && %s", genreWithDescendants(args, genre)))
+			}
+		} else {
+			conditions = append(conditions, fmt.Sprintf("genres @> %s", args.add(pq.Array(search.Genres))))
+		}
+	}
+	if len(search.Tags) > 0 && !validator.PermittedValue("tags", exclude...) {
+		conditions = append(conditions, fmt.Sprintf(
+			"id IN (SELECT album_id FROM album_tags WHERE user_id = %s AND tag = ANY(%s) GROUP BY album_id HAVING count(*) = %s)",
+			args.add(search.UserID), args.add(pq.Array(search.Tags)), args.add(len(search.Tags))))
+	}
+	if search.Decade != 0 && !validator.PermittedValue("decade", exclude...) {
+		conditions = append(conditions, fmt.Sprintf("year >= %[1]s AND year < %[1]s + 10", args.add(search.Decade)))
+	}
+	if search.AfterID > 0 {
+		conditions = append(conditions, fmt.Sprintf("id > %s", args.add(search.AfterID)))
+	}
+	if search.CollectionUserID > 0 {
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT album_id FROM copies WHERE user_id = %s)", args.add(search.CollectionUserID)))
+	}
+	if search.Filter != nil {
+		conditions = append(conditions, search.Filter.sql(args))
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+func ValidateAlbumSearch(v *validator.Validator, search AlbumSearch) {
+	v.Check(len(search.Query) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(search.Decade%10 == 0, "decade", "must be the first year of a decade, such as 1970")
+}
+
+func albumSortValue(album *Album, column string) string {
+	switch column {
+	case "title":
+		return album.Title
+	case "artist":
+		return album.Artist
+	case "relevance":
+		if album.Search != nil {
+			return strconv.FormatFloat(album.Search.Rank, 'g', -1, 32)
+		}
+		return strconv.FormatInt(album.ID, 10)
+	default:
+		return strconv.FormatInt(album.ID, 10)
+	}
+}
+
+func (a AlbumModel) GetAll(search AlbumSearch, filters Filters) ([]*Album, Metadata, error) {
+	args := queryArgs{}
+	where := albumWhere(&args, search)
+	whereArgs := len(args)
+
+	columns := "id, created_at, title, artist, genres, COALESCE(year, 0), version"
+	sortColumn, sortDirection := filters.sortColumn(), filters.sortDirection()
+
+	tsq := prefixTSQuery(search.Query)
+	if tsq != "" {
+		var rank string
+
+		if search.Fuzzy {
+			q := args.add(strings.ToLower(search.Query))
+			rank = fmt.Sprintf("GREATEST(word_similarity(%[1]s, lower(title)), word_similarity(%[1]s, lower(artist)))", q)
+			columns += ", " + rank
+		} else {
+			q := args.add(tsq)
+			rank = fmt.Sprintf("ts_rank(search_vector, %s)", tsQuery(q))
+			columns += fmt.Sprintf(", %s, %s, %s, %s", rank, tsHeadline("title", q), tsHeadline("artist", q), tsHeadline("array_to_string(genres, ', ')", q))
+		}
+
+		if sortColumn == "relevance" {
+			sortColumn, sortDirection = rank, "DESC"
+		}
+	}
+
+	// Without any words to rank by, relevance order falls back to id order.
+	if sortColumn == "relevance" {
+		sortColumn = "id"
+	}
+
+	page, err := filters.keyset(&args, sortColumn, sortDirection)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	countColumn := ""
+	if filters.countMode() == CountExact && page.cursor == nil {
+		countColumn = "count(*) OVER(), "
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s %s
+		FROM albums
+		%s %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`, countColumn, columns, where, page.condition, page.orderBy, args.add(filters.limit()+1), args.add(page.offset))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
 	rows, err := a.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -109,83 +397,407 @@ func (a AlbumModel) GetAll(title, artist string, genres []string, filters Filter
 
 	for rows.Next() {
 		var album Album
-		err := rows.Scan(
-			&totalRecords,
+
+		dest := []interface{}{
 			&album.ID,
 			&album.CreatedAt,
 			&album.Title,
 			&album.Artist,
 			pq.Array(&album.Genres),
+			&album.Year,
 			&album.Version,
-		)
+		}
+		if countColumn != "" {
+			dest = append([]interface{}{&totalRecords}, dest...)
+		}
+
+		var highlights [3]string
+		if tsq != "" {
+			album.Search = &SearchMatch{}
+			dest = append(dest, &album.Search.Rank)
+
+			if !search.Fuzzy {
+				dest = append(dest, &highlights[0], &highlights[1], &highlights[2])
+			}
+		}
+
+		err := rows.Scan(dest...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+
+		if album.Search != nil && !search.Fuzzy {
+			album.Search.Highlights = map[string]string{
+				"title":  highlights[0],
+				"artist": highlights[1],
+				"genres": highlights[2],
+			}
+		}
+
 		albums = append(albums, &album)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	albums, hasPrev, hasNext := trimPage(page, albums, filters.limit())
+
+	metadata := Metadata{}
+
+	switch {
+	case countColumn != "":
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	case filters.countMode() != CountNone:
+		total, estimated, err := countRows(ctx, a.DB, filters.countMode(), "FROM albums "+where, args[:whereArgs]...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		metadata = calculateMetadata(total, filters.Page, filters.PageSize)
+		metadata.TotalIsEstimate = estimated
+	}
+
+	if page.cursor != nil {
+		metadata.CurrentPage = 0
+	}
+
+	if len(albums) > 0 {
+		column := filters.sortColumn()
+		if hasNext {
+			last := albums[len(albums)-1]
+			metadata.NextCursor = encodeCursor(cursor{Sort: filters.Sort, Value: albumSortValue(last, column), ID: last.ID, Fuzzy: search.Fuzzy})
+		}
+		if hasPrev {
+			first := albums[0]
+			metadata.PrevCursor = encodeCursor(cursor{Sort: filters.Sort, Value: albumSortValue(first, column), ID: first.ID, Backward: true, Fuzzy: search.Fuzzy})
+		}
+	}
+
 	return albums, metadata, nil
 }
 
-func (a AlbumModel) Update(album *Album) error {
+func (a AlbumModel) Update(album *Album, actor Actor) error {
+	return a.update(album, actor, RevisionUpdate, nil)
+}
+
+// Restore saves album as a restore of revision. The caller copies the
+// revision's fields onto album and validates it first.
+func (a AlbumModel) Restore(album *Album, revision *AlbumRevision, actor Actor) error {
+	return a.update(album, actor, RevisionRestore, &revision.Version)
+}
+
+func (a AlbumModel) update(album *Album, actor Actor, action string, restoredFrom *int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateAlbum(ctx, tx, album, actor, action, restoredFrom)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateAlbum(ctx context.Context, tx *sql.Tx, album *Album, actor Actor, action string, restoredFrom *int32) error {
+	revision, err := saveAlbum(ctx, tx, album, actor, action)
+	if err != nil {
+		return err
+	}
+	revision.RestoredFrom = restoredFrom
+
+	return writeAlbumRevision(ctx, tx, revision, actor)
+}
+
+// saveAlbum writes an album's fields, checking its version, and returns the
+// revision describing the change for the caller to complete and write.
+func saveAlbum(ctx context.Context, tx *sql.Tx, album *Album, actor Actor, action string) (*AlbumRevision, error) {
 	query := `
+		SELECT title, artist, genres, COALESCE(year, 0)
+		FROM albums
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		FOR UPDATE`
+
+	var old Album
+
+	err := tx.QueryRowContext(ctx, query, album.ID, album.Version).Scan(&old.Title, &old.Artist, pq.Array(&old.Genres), &old.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
 		UPDATE albums
-		SET title = $1, artist = $2, genres = $3, version = version + 1
-		WHERE id = $4 AND version = $5
+		SET title = $1, artist = $2, genres = $3, year = NULLIF($4, 0), version = version + 1
+		WHERE id = $5 AND version = $6
 		RETURNING version`
 
 	args := []interface{}{
 		album.Title,
 		album.Artist,
 		pq.Array(album.Genres),
+		album.Year,
 		album.ID,
 		album.Version,
 	}
 
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&album.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	return newAlbumRevision(album, &old, actor.UserID, action), nil
+}
+
+const deleteAlbumQuery = `
+	UPDATE albums
+	SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING id, created_at, title, artist, genres, COALESCE(year, 0), version, deleted_at`
+
+func (a AlbumModel) Delete(id int64, actor Actor) error {
+	_, err := a.setDeleted(deleteAlbumQuery, id, actor, RevisionDelete)
+	return err
+}
+
+func (a AlbumModel) Undelete(id int64, actor Actor) (*Album, error) {
+	query := `
+		UPDATE albums
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, title, artist, genres, COALESCE(year, 0), version, deleted_at`
+
+	return a.setDeleted(query, id, actor, RevisionUndelete)
+}
+
+func (a AlbumModel) setDeleted(query string, id int64, actor Actor, action string) (*Album, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := a.DB.QueryRowContext(ctx, query, args...).Scan(&album.Version)
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	album, err := setAlbumDeleted(ctx, tx, query, id, actor, action)
+	if err != nil {
+		return nil, err
+	}
+
+	return album, tx.Commit()
+}
+
+func setAlbumDeleted(ctx context.Context, tx *sql.Tx, query string, id int64, actor Actor, action string) (*Album, error) {
+	var album Album
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(
+		&album.ID,
+		&album.CreatedAt,
+		&album.Title,
+		&album.Artist,
+		pq.Array(&album.Genres),
+		&album.Year,
+		&album.Version,
+		&album.DeletedAt,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
-	return nil
+
+	revision := newAlbumRevision(&album, &album, actor.UserID, action)
+	revision.Changes["deleted"] = FieldChange{Old: action == RevisionUndelete, New: action == RevisionDelete}
+
+	err = writeAlbumRevision(ctx, tx, revision, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &album, nil
 }
 
-func (a AlbumModel) Delete(id int64) error {
+func (a AlbumModel) GetAllDeleted(filters Filters) ([]*Album, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, artist, genres, COALESCE(year, 0), version, deleted_at
+		FROM albums
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	albums := []*Album{}
+
+	for rows.Next() {
+		var album Album
+		err := rows.Scan(
+			&totalRecords,
+			&album.ID,
+			&album.CreatedAt,
+			&album.Title,
+			&album.Artist,
+			pq.Array(&album.Genres),
+			&album.Year,
+			&album.Version,
+			&album.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		albums = append(albums, &album)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return albums, metadata, nil
+}
+
+func (a AlbumModel) Purge(id int64, actor Actor) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 		DELETE FROM albums
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING title, artist, genres, COALESCE(year, 0)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := a.DB.ExecContext(ctx, query, id)
+	tx, err := a.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	var album Album
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&album.Title, &album.Artist, pq.Array(&album.Genres), &album.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = writeAudit(ctx, tx, actor, "album.purge", "album", id, albumChanges(&album, &Album{}))
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	return tx.Commit()
+}
+
+func (a AlbumModel) PurgeDeletedBefore(cutoff time.Time, actor Actor) (int64, error) {
+	query := `
+		DELETE FROM albums
+		WHERE deleted_at < $1
+		RETURNING id, title, artist, genres, COALESCE(year, 0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	albums := []*Album{}
+
+	for rows.Next() {
+		var album Album
+		err := rows.Scan(&album.ID, &album.Title, &album.Artist, pq.Array(&album.Genres), &album.Year)
+		if err != nil {
+			return 0, err
+		}
+		albums = append(albums, &album)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for _, album := range albums {
+		err = writeAudit(ctx, tx, actor, "album.purge", "album", album.ID, albumChanges(album, &Album{}))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(albums)), tx.Commit()
+}
+
+// Missing returns the IDs among albumIDs that aren't live albums.
+func (a AlbumModel) Missing(albumIDs []int64) (map[int64]bool, error) {
+	query := `
+		SELECT i.id
+		FROM unnest($1::bigint[]) AS i(id)
+		WHERE NOT EXISTS (SELECT 1 FROM albums WHERE albums.id = i.id AND deleted_at IS NULL)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, pq.Array(albumIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	missing := map[int64]bool{}
+
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		missing[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return missing, nil
 }
@@ -0,0 +1,262 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// auditLockID is the transaction-level advisory lock taken before appending
+// to the audit log, so every entry chains onto the one committed before it.
+const auditLockID = 7_262_013
+
+type Actor struct {
+	UserID    int64
+	IP        string
+	RequestID string
+}
+
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *int64          `json:"actor_id"`
+	IP         string          `json:"ip,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   *int64          `json:"target_id"`
+	Diff       json.RawMessage `json:"diff"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+func newAuditEntry(actor Actor, action, targetType string, targetID int64, diff map[string]FieldChange) (*AuditEntry, error) {
+	if diff == nil {
+		diff = map[string]FieldChange{}
+	}
+
+	js, err := json.Marshal(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &AuditEntry{
+		IP:         actor.IP,
+		RequestID:  actor.RequestID,
+		Action:     action,
+		TargetType: targetType,
+		Diff:       js,
+	}
+
+	if actor.UserID > 0 {
+		entry.ActorID = &actor.UserID
+	}
+	if targetID > 0 {
+		entry.TargetID = &targetID
+	}
+
+	return entry, nil
+}
+
+func (e *AuditEntry) computeHash() string {
+	var actorID, targetID string
+	if e.ActorID != nil {
+		actorID = fmt.Sprint(*e.ActorID)
+	}
+	if e.TargetID != nil {
+		targetID = fmt.Sprint(*e.TargetID)
+	}
+
+	fields := []string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		actorID,
+		e.IP,
+		e.RequestID,
+		e.Action,
+		e.TargetType,
+		targetID,
+		string(e.Diff),
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeAudit(ctx context.Context, tx *sql.Tx, actor Actor, action, targetType string, targetID int64, diff map[string]FieldChange) error {
+	entry, err := newAuditEntry(actor, action, targetType, targetID, diff)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditLockID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&entry.PrevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	entry.Hash = entry.computeHash()
+
+	query := `
+		INSERT INTO audit_log (created_at, actor_id, ip, request_id, action, target_type, target_id, diff, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`
+
+	args := []interface{}{
+		entry.CreatedAt,
+		entry.ActorID,
+		entry.IP,
+		entry.RequestID,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		[]byte(entry.Diff),
+		entry.PrevHash,
+		entry.Hash,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID)
+}
+
+type AuditFilters struct {
+	ActorID    int64
+	TargetType string
+	TargetID   int64
+	Action     string
+	From       *time.Time
+	To         *time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) GetAll(auditFilters AuditFilters, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, ip, request_id, action, target_type, target_id, diff, prev_hash, hash
+		FROM audit_log
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (target_type = $2 OR $2 = '')
+		AND (target_id = $3 OR $3 = 0)
+		AND (action = $4 OR $4 = '')
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY %s %s
+		LIMIT $7 OFFSET $8`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		auditFilters.ActorID,
+		auditFilters.TargetType,
+		auditFilters.TargetID,
+		auditFilters.Action,
+		auditFilters.From,
+		auditFilters.To,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// Verify walks the whole chain in order and returns the ID of the first entry
+// whose hash or link to its predecessor doesn't match, or 0 if the log is
+// intact.
+func (m AuditModel) Verify() (int64, int64, error) {
+	query := `
+		SELECT id, created_at, actor_id, ip, request_id, action, target_type, target_id, diff, prev_hash, hash
+		FROM audit_log
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var checked int64
+	prevHash := ""
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return 0, checked, err
+		}
+
+		if entry.PrevHash != prevHash || entry.computeHash() != entry.Hash {
+			return entry.ID, checked, nil
+		}
+
+		prevHash = entry.Hash
+		checked++
+	}
+	if err = rows.Err(); err != nil {
+		return 0, checked, err
+	}
+
+	return 0, checked, nil
+}
+
+func scanAuditEntry(row scanner, extra ...interface{}) (*AuditEntry, error) {
+	var entry AuditEntry
+	var diff []byte
+
+	dest := append(extra,
+		&entry.ID,
+		&entry.CreatedAt,
+		&entry.ActorID,
+		&entry.IP,
+		&entry.RequestID,
+		&entry.Action,
+		&entry.TargetType,
+		&entry.TargetID,
+		&diff,
+		&entry.PrevHash,
+		&entry.Hash,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Diff = diff
+	return &entry, nil
+}
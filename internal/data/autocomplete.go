@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var AutocompleteSafelist = []string{"artist", "title", "genre"}
+
+// Autocomplete returns the most common distinct values of field starting with
+// prefix (case-insensitively), with how many albums have each. Genres are
+// completed from the taxonomy, matching the keys of their names and aliases,
+// so unused genres are suggested too.
+func (a AlbumModel) Autocomplete(field, prefix string, limit int) ([]FacetCount, error) {
+	pattern := escapeLike(strings.ToLower(prefix)) + "%"
+
+	var query string
+
+	switch field {
+	case "artist", "title":
+		query = fmt.Sprintf(`
+			SELECT %[1]s, count(*)
+			FROM albums
+			WHERE deleted_at IS NULL AND lower(%[1]s) LIKE $1
+			GROUP BY %[1]s
+			ORDER BY count(*) DESC, %[1]s ASC
+			LIMIT $2`, field)
+	case "genre":
+		pattern = escapeLike(GenreKey(prefix)) + "%"
+		query = `
+			SELECT g.name, (SELECT count(*) FROM albums a WHERE a.deleted_at IS NULL AND a.genres @> ARRAY[g.name]) AS uses
+			FROM genres g
+			WHERE g.key LIKE $1
+			OR g.id IN (SELECT genre_id FROM genre_aliases WHERE key LIKE $1)
+			ORDER BY uses DESC, g.name ASC
+			LIMIT $2`
+	default:
+		panic("unsafe autocomplete field: " + field)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return a.facetCounts(ctx, query, pattern, limit)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
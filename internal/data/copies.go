@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+// CopyConditions are the Goldmine grades a copy can be given, best first.
+var CopyConditions = []string{"M", "NM", "VG+", "VG", "G+", "G", "F", "P"}
+
+// Copy is a physical copy of an album in a user's collection.
+type Copy struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	AlbumID    int64      `json:"album_id"`
+	Album      *Album     `json:"album,omitempty"`
+	Condition  string     `json:"condition,omitempty"`
+	Notes      string     `json:"notes,omitempty"`
+	AcquiredAt *time.Time `json:"acquired_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Version    int32      `json:"version"`
+}
+
+func ValidateCopy(v *validator.Validator, copy *Copy) {
+	v.Check(copy.AlbumID > 0, "album_id", "must be provided")
+	v.Check(copy.Condition == "" || validator.PermittedValue(copy.Condition, CopyConditions...), "condition", "must be one of M, NM, VG+, VG, G+, G, F or P")
+	v.Check(len(copy.Notes) <= 1000, "notes", "must not be more than 1000 bytes long")
+	if copy.AcquiredAt != nil {
+		v.Check(copy.AcquiredAt.Before(time.Now().Add(24*time.Hour)), "acquired_at", "must not be in the future")
+	}
+}
+
+type CopyModel struct {
+	DB *sql.DB
+}
+
+const copyColumns = `
+	c.id, c.user_id, c.album_id, c.condition, c.notes, c.acquired_at, c.created_at, c.version,
+	a.title, a.artist, a.genres, COALESCE(a.year, 0), a.version`
+
+func (m CopyModel) Insert(copy *Copy, actor Actor) error {
+	query := `
+		INSERT INTO copies (user_id, album_id, condition, notes, acquired_at)
+		SELECT $1, id, $3, $4, $5 FROM albums WHERE id = $2 AND deleted_at IS NULL
+		RETURNING id, created_at, version`
+
+	args := []interface{}{copy.UserID, copy.AlbumID, copy.Condition, copy.Notes, copy.AcquiredAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&copy.ID, &copy.CreatedAt, &copy.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "copy.create", "copy", copy.ID, copyChanges(&Copy{}, copy))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CopyModel) Get(id, userID int64) (*Copy, error) {
+	query := `
+		SELECT ` + copyColumns + `
+		FROM copies c
+		INNER JOIN albums a ON a.id = c.album_id
+		WHERE c.id = $1 AND c.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	copy, err := scanCopy(m.DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return copy, nil
+}
+
+// GetAllForUser returns a user's copies, optionally only those of one album.
+// Copies can be sorted by the album's title or artist, or by acquired_at.
+// Copies of trashed albums are left out until the album is restored.
+func (m CopyModel) GetAllForUser(userID, albumID int64, filters Filters) ([]*Copy, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT `+copyColumns+`, count(*) OVER()
+		FROM copies c
+		INNER JOIN albums a ON a.id = c.album_id
+		WHERE c.user_id = $1
+		AND (c.album_id = $2 OR $2 = 0)
+		AND a.deleted_at IS NULL
+		ORDER BY %s %s NULLS LAST, c.id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, albumID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	copies := []*Copy{}
+
+	for rows.Next() {
+		var total int
+
+		copy, err := scanCopy(rows, &total)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		totalRecords = total
+		copies = append(copies, copy)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return copies, metadata, nil
+}
+
+func (m CopyModel) Update(copy *Copy, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT condition, notes, acquired_at
+		FROM copies
+		WHERE id = $1 AND user_id = $2 AND version = $3
+		FOR UPDATE`
+
+	var old Copy
+
+	err = tx.QueryRowContext(ctx, query, copy.ID, copy.UserID, copy.Version).Scan(&old.Condition, &old.Notes, &old.AcquiredAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE copies
+		SET condition = $1, notes = $2, acquired_at = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{copy.Condition, copy.Notes, copy.AcquiredAt, copy.ID, copy.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&copy.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "copy.update", "copy", copy.ID, copyChanges(&old, copy))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a copy from the user's collection, along with its loans.
+func (m CopyModel) Delete(id, userID int64, actor Actor) error {
+	query := `
+		DELETE FROM copies
+		WHERE id = $1 AND user_id = $2
+		RETURNING album_id, condition, notes, acquired_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old Copy
+
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&old.AlbumID, &old.Condition, &old.Notes, &old.AcquiredAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "copy.delete", "copy", id, copyChanges(&old, &Copy{}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func copyChanges(old, new *Copy) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.AlbumID != new.AlbumID {
+		changes["album_id"] = FieldChange{Old: nilIfZeroID(old.AlbumID), New: nilIfZeroID(new.AlbumID)}
+	}
+	if old.Condition != new.Condition {
+		changes["condition"] = FieldChange{Old: nilIfEmpty(old.Condition), New: nilIfEmpty(new.Condition)}
+	}
+	if old.Notes != new.Notes {
+		changes["notes"] = FieldChange{Old: nilIfEmpty(old.Notes), New: nilIfEmpty(new.Notes)}
+	}
+	if !equalTimes(old.AcquiredAt, new.AcquiredAt) {
+		changes["acquired_at"] = FieldChange{Old: nilIfNoTime(old.AcquiredAt), New: nilIfNoTime(new.AcquiredAt)}
+	}
+
+	return changes
+}
+
+func nilIfZeroID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func nilIfNoTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return *t
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func scanCopy(row scanner, extra ...interface{}) (*Copy, error) {
+	copy := Copy{Album: &Album{}}
+
+	dest := append([]interface{}{
+		&copy.ID,
+		&copy.UserID,
+		&copy.AlbumID,
+		&copy.Condition,
+		&copy.Notes,
+		&copy.AcquiredAt,
+		&copy.CreatedAt,
+		&copy.Version,
+		&copy.Album.Title,
+		&copy.Album.Artist,
+		pq.Array(&copy.Album.Genres),
+		&copy.Album.Year,
+		&copy.Album.Version,
+	}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	copy.Album.ID = copy.AlbumID
+
+	return &copy, nil
+}
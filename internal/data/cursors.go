@@ -0,0 +1,80 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor marks a position in a keyset-paginated listing: the value of the
+// sort column and the id tie-breaker of the row to continue after (or
+// before, when Backward is set). Fuzzy records that it was issued for a
+// fuzzy search, so following it stays fuzzy.
+type cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+	Fuzzy    bool   `json:"f,omitempty"`
+}
+
+var cursorKey = func() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// SetCursorKey sets the key used to sign pagination cursors. Without it a
+// random key is used, so cursors don't survive a restart.
+func SetCursorKey(key []byte) {
+	cursorKey = key
+}
+
+func signCursor(payload string) string {
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeCursor(c cursor) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(js)
+	return payload + "." + signCursor(payload)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	payload, signature, found := strings.Cut(s, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(signCursor(payload))) {
+		return nil, ErrInvalidCursor
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,240 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	DuplicateSimilar = "similar_title_artist"
+	DuplicateBarcode = "barcode"
+)
+
+// DuplicateCandidate is a pair of live albums that look like the same
+// release. Score runs from 0 to 1; a shared barcode always scores 1.
+type DuplicateCandidate struct {
+	Album     *Album   `json:"album"`
+	Duplicate *Album   `json:"duplicate"`
+	Score     float64  `json:"score"`
+	Reasons   []string `json:"reasons"`
+}
+
+// normalizedText lowercases a column and collapses everything but letters and
+// digits to single spaces, so punctuation and spacing don't affect
+// similarity.
+func normalizedText(column string) string {
+	return fmt.Sprintf(`trim(regexp_replace(lower(%s), '[^[:alnum:]]+', ' ', 'g'))`, column)
+}
+
+// FindDuplicates returns up to limit pairs of albums whose normalized titles
+// and artists are at least minScore similar, or which share a barcode from an
+// external catalogue, most likely duplicates first.
+func (a AlbumModel) FindDuplicates(minScore float64, limit int) ([]*DuplicateCandidate, error) {
+	query := fmt.Sprintf(`
+		WITH pairs AS (
+			SELECT a.id AS album_id, b.id AS duplicate_id,
+				(similarity(%[1]s, %[2]s) + similarity(%[3]s, %[4]s)) / 2 AS score,
+				'%[5]s' AS reason
+			FROM albums a
+			INNER JOIN albums b ON b.id > a.id AND lower(b.title) %% lower(a.title) AND lower(b.artist) %% lower(a.artist)
+			WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+			UNION ALL
+			SELECT sa.album_id, sb.album_id, 1, '%[6]s'
+			FROM album_sources sa
+			INNER JOIN album_sources sb ON sb.metadata->>'barcode' = sa.metadata->>'barcode' AND sb.album_id > sa.album_id
+			INNER JOIN albums a ON a.id = sa.album_id AND a.deleted_at IS NULL
+			INNER JOIN albums b ON b.id = sb.album_id AND b.deleted_at IS NULL
+			WHERE sa.metadata->>'barcode' <> ''
+		), candidates AS (
+			SELECT album_id, duplicate_id, max(score) AS score, array_agg(DISTINCT reason) AS reasons
+			FROM pairs
+			WHERE score >= $1
+			GROUP BY album_id, duplicate_id
+		)
+		SELECT c.score, c.reasons,
+			a.id, a.created_at, a.title, a.artist, a.genres, COALESCE(a.year, 0), a.version,
+			b.id, b.created_at, b.title, b.artist, b.genres, COALESCE(b.year, 0), b.version
+		FROM candidates c
+		INNER JOIN albums a ON a.id = c.album_id
+		INNER JOIN albums b ON b.id = c.duplicate_id
+		ORDER BY c.score DESC, a.id ASC, b.id ASC
+		LIMIT $2`,
+		normalizedText("a.title"), normalizedText("b.title"),
+		normalizedText("a.artist"), normalizedText("b.artist"),
+		DuplicateSimilar, DuplicateBarcode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, minScore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*DuplicateCandidate{}
+
+	for rows.Next() {
+		candidate := DuplicateCandidate{Album: &Album{}, Duplicate: &Album{}}
+
+		err := rows.Scan(
+			&candidate.Score,
+			pq.Array(&candidate.Reasons),
+			&candidate.Album.ID,
+			&candidate.Album.CreatedAt,
+			&candidate.Album.Title,
+			&candidate.Album.Artist,
+			pq.Array(&candidate.Album.Genres),
+			&candidate.Album.Year,
+			&candidate.Album.Version,
+			&candidate.Duplicate.ID,
+			&candidate.Duplicate.CreatedAt,
+			&candidate.Duplicate.Title,
+			&candidate.Duplicate.Artist,
+			pq.Array(&candidate.Duplicate.Genres),
+			&candidate.Duplicate.Year,
+			&candidate.Duplicate.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, &candidate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// Merge folds the album with the given ID into album: genres are combined,
+// a missing year is filled in, tags, external links, tracks, plays,
+// collection copies and prices are moved across, and the merged album goes to
+// the trash. The merge is recorded in album's history, and the merged album is
+// returned.
+func (a AlbumModel) Merge(album *Album, mergedID int64, actor Actor) (*Album, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT genres, COALESCE(year, 0)
+		FROM albums
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`
+
+	var merged Album
+
+	err = tx.QueryRowContext(ctx, query, mergedID).Scan(pq.Array(&merged.Genres), &merged.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	genres := append([]string{}, album.Genres...)
+	for _, genre := range merged.Genres {
+		if !validator.PermittedValue(genre, genres...) {
+			genres = append(genres, genre)
+		}
+	}
+	album.Genres = genres
+
+	if album.Year == 0 {
+		album.Year = merged.Year
+	}
+
+	revision, err := saveAlbum(ctx, tx, album, actor, RevisionMerge)
+	if err != nil {
+		return nil, err
+	}
+	revision.Changes["merged_from"] = FieldChange{Old: nil, New: mergedID}
+
+	err = writeAlbumRevision(ctx, tx, revision, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each user's tags on the merged album move across unless they already
+	// have the same tag on album. The album's own track list is kept if it
+	// has one.
+	query = `
+		INSERT INTO album_tags (user_id, album_id, tag, created_at)
+		SELECT user_id, $1, tag, created_at FROM album_tags WHERE album_id = $2
+		ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, album.ID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM album_tags WHERE album_id = $1`, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE album_sources SET album_id = $1 WHERE album_id = $2`, album.ID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE copies SET album_id = $1 WHERE album_id = $2`, album.ID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE price_observations SET album_id = $1 WHERE album_id = $2`, album.ID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A play the user also recorded against album at the same moment stays
+	// with the merged album rather than being counted twice.
+	query = `
+		UPDATE plays SET album_id = $1
+		WHERE album_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM plays p
+			WHERE p.user_id = plays.user_id AND p.album_id = $1 AND p.played_at = plays.played_at AND p.track = plays.track
+		)`
+
+	_, err = tx.ExecContext(ctx, query, album.ID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE album_tracks SET album_id = $1
+		WHERE album_id = $2 AND NOT EXISTS (SELECT 1 FROM album_tracks WHERE album_id = $1)`
+
+	_, err = tx.ExecContext(ctx, query, album.ID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := setAlbumDeleted(ctx, tx, deleteAlbumQuery, mergedID, actor, RevisionDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,167 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Track struct {
+	Disc     int    `json:"disc"`
+	Position int    `json:"position"`
+	Title    string `json:"title"`
+	LengthMS int    `json:"length_ms,omitempty"`
+}
+
+// Enrich saves metadata found for an album in an external catalogue: the
+// album itself if changed is set, the link to the catalogue's record, and the
+// track list, which replaces any the album already had.
+func (a AlbumModel) Enrich(album *Album, changed bool, source *AlbumSource, tracks []Track, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if changed {
+		err = updateAlbum(ctx, tx, album, actor, RevisionEnrich, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	source.AlbumID = album.ID
+
+	err = insertAlbumSource(ctx, tx, source, actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM album_tracks WHERE album_id = $1`, album.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(tracks) > 0 {
+		discs := make([]int64, len(tracks))
+		positions := make([]int64, len(tracks))
+		titles := make([]string, len(tracks))
+		lengths := make([]int64, len(tracks))
+
+		for i, track := range tracks {
+			discs[i] = int64(track.Disc)
+			positions[i] = int64(track.Position)
+			titles[i] = track.Title
+			lengths[i] = int64(track.LengthMS)
+		}
+
+		query := `
+			INSERT INTO album_tracks (album_id, disc, position, title, length_ms)
+			SELECT $1, disc, position, title, NULLIF(length_ms, 0)
+			FROM unnest($2::integer[], $3::integer[], $4::text[], $5::integer[]) AS t(disc, position, title, length_ms)
+			ON CONFLICT DO NOTHING`
+
+		_, err = tx.ExecContext(ctx, query, album.ID, pq.Array(discs), pq.Array(positions), pq.Array(titles), pq.Array(lengths))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE albums SET enriched_at = NOW() WHERE id = $1`, album.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkEnriched records that an album has been looked up, so the enrichment
+// worker doesn't try it again.
+func (a AlbumModel) MarkEnriched(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := a.DB.ExecContext(ctx, `UPDATE albums SET enriched_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// GetUnenriched returns up to limit live albums that have never been looked
+// up, oldest first.
+func (a AlbumModel) GetUnenriched(limit int) ([]*Album, error) {
+	query := `
+		SELECT id, created_at, title, artist, genres, COALESCE(year, 0), version
+		FROM albums
+		WHERE enriched_at IS NULL AND deleted_at IS NULL
+		ORDER BY id ASC
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []*Album{}
+
+	for rows.Next() {
+		var album Album
+		err := rows.Scan(
+			&album.ID,
+			&album.CreatedAt,
+			&album.Title,
+			&album.Artist,
+			pq.Array(&album.Genres),
+			&album.Year,
+			&album.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, &album)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+func (a AlbumModel) GetTracks(albumID int64) ([]Track, error) {
+	query := `
+		SELECT disc, position, title, COALESCE(length_ms, 0)
+		FROM album_tracks
+		WHERE album_id = $1
+		ORDER BY disc, position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []Track{}
+
+	for rows.Next() {
+		var track Track
+		err := rows.Scan(&track.Disc, &track.Position, &track.Title, &track.LengthMS)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
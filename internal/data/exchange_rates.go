@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// ExchangeRate is the value of one unit of Currency in the base currency
+// configured for valuations.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      float64   `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateExchangeRate(v *validator.Validator, rate *ExchangeRate) {
+	v.Check(validator.Matches(rate.Currency, validator.CurrencyRX), "currency", "must be a three-letter ISO 4217 code, such as USD")
+	v.Check(rate.Rate > 0, "rate", "must be greater than zero")
+	v.Check(rate.Rate < 1e9, "rate", "must be less than 1000000000")
+}
+
+// Rates maps currencies to the value of one unit in a base currency.
+type Rates map[string]float64
+
+// Convert converts amount from one currency to another, reporting false if
+// either currency has no rate.
+func (r Rates) Convert(amount float64, from, to string) (float64, bool) {
+	fromRate, ok := r[from]
+	if !ok {
+		return 0, false
+	}
+
+	toRate, ok := r[to]
+	if !ok {
+		return 0, false
+	}
+
+	return amount * fromRate / toRate, true
+}
+
+type ExchangeRateModel struct {
+	DB *sql.DB
+}
+
+func (m ExchangeRateModel) GetAll() ([]*ExchangeRate, error) {
+	query := `
+		SELECT currency, rate, updated_at
+		FROM exchange_rates
+		ORDER BY currency ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		var rate ExchangeRate
+		err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, &rate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// Rates returns every exchange rate, along with a rate of 1 for the base
+// currency.
+func (m ExchangeRateModel) Rates(base string) (Rates, error) {
+	all, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rates := Rates{}
+	for _, rate := range all {
+		rates[rate.Currency] = rate.Rate
+	}
+	rates[base] = 1
+
+	return rates, nil
+}
+
+// Set adds or replaces the rate for a currency.
+func (m ExchangeRateModel) Set(rate *ExchangeRate, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old sql.NullFloat64
+
+	err = tx.QueryRowContext(ctx, `SELECT rate FROM exchange_rates WHERE currency = $1 FOR UPDATE`, rate.Currency).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query := `
+		INSERT INTO exchange_rates (currency, rate)
+		VALUES ($1, $2)
+		ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = NOW()
+		RETURNING updated_at`
+
+	err = tx.QueryRowContext(ctx, query, rate.Currency, rate.Rate).Scan(&rate.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	change := FieldChange{Old: nil, New: rate.Rate}
+	if old.Valid {
+		change.Old = old.Float64
+	}
+
+	err = writeAudit(ctx, tx, actor, "exchange_rate.set", "exchange_rate", 0, map[string]FieldChange{
+		"currency": {Old: nil, New: rate.Currency},
+		"rate":     change,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ExchangeRateModel) Delete(currency string, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old float64
+
+	err = tx.QueryRowContext(ctx, `DELETE FROM exchange_rates WHERE currency = $1 RETURNING rate`, currency).Scan(&old)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "exchange_rate.delete", "exchange_rate", 0, map[string]FieldChange{
+		"currency": {Old: currency, New: nil},
+		"rate":     {Old: old, New: nil},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var FacetSafelist = []string{"genres", "artist", "decade"}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type Facets map[string][]FacetCount
+
+func (a AlbumModel) GetFacets(search AlbumSearch, facets []string, limit int) (Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := Facets{}
+
+	for _, facet := range facets {
+		args := queryArgs{}
+		where := albumWhere(&args, search, facet)
+
+		var query string
+
+		switch facet {
+		case "genres":
+			query = fmt.Sprintf(`
+				SELECT genre, count(*)
+				FROM albums, unnest(genres) AS genre
+				%s
+				GROUP BY genre
+				ORDER BY count(*) DESC, genre ASC
+				LIMIT %s`, where, args.add(limit))
+		case "artist":
+			query = fmt.Sprintf(`
+				SELECT artist, count(*)
+				FROM albums
+				%s
+				GROUP BY artist
+				ORDER BY count(*) DESC, artist ASC
+				LIMIT %s`, where, args.add(limit))
+		case "decade":
+			query = fmt.Sprintf(`
+				SELECT (year / 10) * 10, count(*)
+				FROM albums
+				%s AND year IS NOT NULL
+				GROUP BY 1
+				ORDER BY 1 ASC
+				LIMIT %s`, where, args.add(limit))
+		default:
+			panic("unsafe facet: " + facet)
+		}
+
+		counts, err := a.facetCounts(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		result[facet] = counts
+	}
+
+	return result, nil
+}
+
+func (a AlbumModel) facetCounts(ctx context.Context, query string, args ...interface{}) ([]FacetCount, error) {
+	rows, err := a.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+
+	for rows.Next() {
+		var count FacetCount
+		err := rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,457 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+// maxFilterDepth bounds how deeply NOT and parentheses can nest in a filter
+// expression.
+const maxFilterDepth = 32
+
+// FilterError reports a syntax or semantic error in a filter expression.
+// Pos is the 1-based byte offset of the offending token.
+type FilterError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// FilterExpr is a parsed filter expression, such as
+//
+//	genres:any(jazz,funk) AND year>=1970 AND NOT artist:"Miles Davis"
+//
+// which compiles to a parameterized SQL condition on the albums table.
+type FilterExpr interface {
+	sql(args *queryArgs) string
+}
+
+type filterBinary struct {
+	op          string
+	left, right FilterExpr
+}
+
+func (f filterBinary) sql(args *queryArgs) string {
+	return fmt.Sprintf("(%s %s %s)", f.left.sql(args), f.op, f.right.sql(args))
+}
+
+type filterNot struct {
+	expr FilterExpr
+}
+
+// Comparisons against a NULL year are NULL, so they're treated as false
+// before negating; otherwise NOT year>=1970 would never match unknown years.
+func (f filterNot) sql(args *queryArgs) string {
+	return fmt.Sprintf("NOT COALESCE(%s, false)", f.expr.sql(args))
+}
+
+type filterFieldKind int
+
+const (
+	filterText filterFieldKind = iota
+	filterList
+	filterNumber
+)
+
+var filterFields = map[string]filterFieldKind{
+	"title":  filterText,
+	"artist": filterText,
+	"genres": filterList,
+	"year":   filterNumber,
+}
+
+var filterOperators = map[filterFieldKind][]string{
+	filterText:   {":", "=", "!=", "~"},
+	filterList:   {":"},
+	filterNumber: {":", "=", "!=", ">", ">=", "<", "<="},
+}
+
+var filterQuantifiers = map[filterFieldKind][]string{
+	filterText:   {"any"},
+	filterList:   {"any", "all"},
+	filterNumber: {"any"},
+}
+
+type filterTerm struct {
+	field      string
+	op         string
+	quantifier string
+	values     []string
+	numbers    []int64
+}
+
+func (f filterTerm) sql(args *queryArgs) string {
+	switch filterFields[f.field] {
+	case filterText:
+		switch {
+		case f.quantifier == "any":
+			lowered := make([]string, len(f.values))
+			for i := range f.values {
+				lowered[i] = strings.ToLower(f.values[i])
+			}
+			return fmt.Sprintf("lower(%s) = ANY(%s)", f.field, args.add(pq.Array(lowered)))
+		case f.op == "~":
+			return fmt.Sprintf("%s ILIKE %s", f.field, args.add("%"+escapeLike(f.values[0])+"%"))
+		case f.op == "!=":
+			return fmt.Sprintf("lower(%s) <> lower(%s)", f.field, args.add(f.values[0]))
+		default:
+			return fmt.Sprintf("lower(%s) = lower(%s)", f.field, args.add(f.values[0]))
+		}
+	case filterList:
+		switch f.quantifier {
+		case "any":
+			return fmt.Sprintf("%s && %s", f.field, args.add(pq.Array(f.values)))
+		case "all":
+			return fmt.Sprintf("%s @> %s", f.field, args.add(pq.Array(f.values)))
+		default:
+			return fmt.Sprintf("%s = ANY(%s)", args.add(f.values[0]), f.field)
+		}
+	default:
+		if f.quantifier == "any" {
+			return fmt.Sprintf("%s = ANY(%s)", f.field, args.add(pq.Array(f.numbers)))
+		}
+		op := f.op
+		switch op {
+		case ":":
+			op = "="
+		case "!=":
+			op = "<>"
+		}
+		return fmt.Sprintf("%s %s %s", f.field, op, args.add(f.numbers[0]))
+	}
+}
+
+type filterTokenKind int
+
+const (
+	tokenEOF filterTokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+func (t filterToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+func isFilterDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`()",:=!<>~`, r)
+}
+
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	// decode returns the rune at i, rejecting invalid UTF-8, which Postgres
+	// would refuse in a query parameter.
+	decode := func(i int) (rune, int, error) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return 0, 0, &FilterError{Pos: i + 1, Msg: "invalid UTF-8"}
+		}
+		return r, size, nil
+	}
+
+	for i := 0; i < len(s); {
+		r, size, err := decode(i)
+		if err != nil {
+			return nil, err
+		}
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, filterToken{tokenLParen, "(", start + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{tokenRParen, ")", start + 1})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{tokenComma, ",", start + 1})
+			i++
+		case r == ':' || r == '=' || r == '~':
+			tokens = append(tokens, filterToken{tokenOperator, string(r), start + 1})
+			i++
+		case r == '<' || r == '>' || r == '!':
+			i++
+			if i < len(s) && s[i] == '=' {
+				i++
+			} else if r == '!' {
+				return nil, &FilterError{Pos: start + 1, Msg: `expected "=" after "!"`}
+			}
+			tokens = append(tokens, filterToken{tokenOperator, s[start:i], start + 1})
+		case r == '"':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, &FilterError{Pos: start + 1, Msg: "unterminated string"}
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				r, size, err := decode(i)
+				if err != nil {
+					return nil, err
+				}
+				sb.WriteRune(r)
+				i += size
+			}
+			tokens = append(tokens, filterToken{tokenString, sb.String(), start + 1})
+		default:
+			for i < len(s) {
+				r, size, err := decode(i)
+				if err != nil {
+					return nil, err
+				}
+				if isFilterDelimiter(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, filterToken{tokenWord, s[start:i], start + 1})
+		}
+	}
+
+	return append(tokens, filterToken{tokenEOF, "", len(s) + 1}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	depth  int
+}
+
+// ParseFilter parses a filter expression. Terms take the form field:value,
+// field<op>value or field:any(a,b) / field:all(a,b), and combine with AND,
+// OR, NOT and parentheses. An empty expression returns a nil FilterExpr.
+func ParseFilter(s string) (FilterExpr, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	tokens, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+
+	return expr, nil
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) keyword(word string) bool {
+	tok := p.peek()
+	if tok.kind == tokenWord && strings.EqualFold(tok.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(tok filterToken, format string, a ...interface{}) error {
+	return &FilterError{Pos: tok.pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterBinary{op: "OR", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterBinary{op: "AND", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (FilterExpr, error) {
+	tok := p.peek()
+
+	if p.keyword("NOT") {
+		if p.depth++; p.depth > maxFilterDepth {
+			return nil, p.errorf(tok, "filter is nested too deeply")
+		}
+		defer func() { p.depth-- }()
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (FilterExpr, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		if p.depth++; p.depth > maxFilterDepth {
+			return nil, p.errorf(tok, "filter is nested too deeply")
+		}
+		defer func() { p.depth-- }()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, `expected ")" but found %s`, closing)
+		}
+		return expr, nil
+	case tokenWord:
+		return p.parseTerm(tok)
+	default:
+		return nil, p.errorf(tok, "expected a field name but found %s", tok)
+	}
+}
+
+func (p *filterParser) parseTerm(fieldTok filterToken) (FilterExpr, error) {
+	term := filterTerm{field: strings.ToLower(fieldTok.text)}
+
+	kind, ok := filterFields[term.field]
+	if !ok {
+		return nil, p.errorf(fieldTok, "unknown field %s", fieldTok)
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokenOperator {
+		return nil, p.errorf(opTok, "expected an operator after %s but found %s", fieldTok, opTok)
+	}
+	if !validator.PermittedValue(opTok.text, filterOperators[kind]...) {
+		return nil, p.errorf(opTok, "operator %s is not supported for %s", opTok, term.field)
+	}
+	term.op = opTok.text
+
+	valueTok := p.next()
+
+	if valueTok.kind == tokenWord && p.peek().kind == tokenLParen {
+		term.quantifier = strings.ToLower(valueTok.text)
+		if !validator.PermittedValue(term.quantifier, filterQuantifiers[kind]...) {
+			return nil, p.errorf(valueTok, "%s is not supported for %s", valueTok, term.field)
+		}
+		if term.op != ":" && term.op != "=" {
+			return nil, p.errorf(opTok, "%s(...) requires the : operator", term.quantifier)
+		}
+		p.next()
+
+		for {
+			tok := p.next()
+			if tok.kind != tokenWord && tok.kind != tokenString {
+				return nil, p.errorf(tok, "expected a value but found %s", tok)
+			}
+			if err := p.addValue(&term, kind, tok); err != nil {
+				return nil, err
+			}
+
+			sep := p.next()
+			if sep.kind == tokenRParen {
+				break
+			}
+			if sep.kind != tokenComma {
+				return nil, p.errorf(sep, `expected "," or ")" but found %s`, sep)
+			}
+		}
+
+		return term, nil
+	}
+
+	if valueTok.kind != tokenWord && valueTok.kind != tokenString {
+		return nil, p.errorf(valueTok, "expected a value but found %s", valueTok)
+	}
+	if err := p.addValue(&term, kind, valueTok); err != nil {
+		return nil, err
+	}
+
+	return term, nil
+}
+
+func (p *filterParser) addValue(term *filterTerm, kind filterFieldKind, tok filterToken) error {
+	if kind == filterNumber {
+		n, err := strconv.ParseInt(tok.text, 10, 32)
+		if err != nil {
+			return p.errorf(tok, "%s must be an integer", term.field)
+		}
+		term.numbers = append(term.numbers, n)
+		return nil
+	}
+
+	if tok.text == "" {
+		return p.errorf(tok, "%s must not be empty", term.field)
+	}
+	term.values = append(term.values, tok.text)
+	return nil
+}
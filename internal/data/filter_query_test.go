@@ -0,0 +1,198 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			filter:   "title:voilà",
+			wantSQL:  "lower(title) = lower($1)",
+			wantArgs: []interface{}{"voilà"},
+		},
+		{
+			filter:   "artist:Åsa",
+			wantSQL:  "lower(artist) = lower($1)",
+			wantArgs: []interface{}{"Åsa"},
+		},
+		{
+			filter:   `artist="Sigur Rós"`,
+			wantSQL:  "lower(artist) = lower($1)",
+			wantArgs: []interface{}{"Sigur Rós"},
+		},
+		{
+			filter:   `title:"say \"hi\" \ü"`,
+			wantSQL:  "lower(title) = lower($1)",
+			wantArgs: []interface{}{`say "hi" ü`},
+		},
+		{
+			filter:   "title~50%_off",
+			wantSQL:  "title ILIKE $1",
+			wantArgs: []interface{}{`%50\%\_off%`},
+		},
+		{
+			filter:   "artist!=Björk",
+			wantSQL:  "lower(artist) <> lower($1)",
+			wantArgs: []interface{}{"Björk"},
+		},
+		{
+			filter:   "artist:any(Can,Neu)",
+			wantSQL:  "lower(artist) = ANY($1)",
+			wantArgs: []interface{}{pq.Array([]string{"can", "neu"})},
+		},
+		{
+			filter:   "genres:jazz",
+			wantSQL:  "$1 = ANY(genres)",
+			wantArgs: []interface{}{"jazz"},
+		},
+		{
+			filter:   "genres:all(jazz, funk)",
+			wantSQL:  "genres @> $1",
+			wantArgs: []interface{}{pq.Array([]string{"jazz", "funk"})},
+		},
+		{
+			filter:   "year>=1970 AND year<1980",
+			wantSQL:  "(year >= $1 AND year < $2)",
+			wantArgs: []interface{}{int64(1970), int64(1980)},
+		},
+		{
+			filter:   "year:any(1959,1969)",
+			wantSQL:  "year = ANY($1)",
+			wantArgs: []interface{}{pq.Array([]int64{1959, 1969})},
+		},
+		{
+			filter:   "genres:any(jazz,funk) AND year>=1970 AND NOT artist:\"Miles Davis\"",
+			wantSQL:  "((genres && $1 AND year >= $2) AND NOT COALESCE(lower(artist) = lower($3), false))",
+			wantArgs: []interface{}{pq.Array([]string{"jazz", "funk"}), int64(1970), "Miles Davis"},
+		},
+		{
+			filter:   "year=1 OR year=2 AND year=3",
+			wantSQL:  "(year = $1 OR (year = $2 AND year = $3))",
+			wantArgs: []interface{}{int64(1), int64(2), int64(3)},
+		},
+		{
+			filter:   "(year=1 or year=2) and not (year!=3)",
+			wantSQL:  "((year = $1 OR year = $2) AND NOT COALESCE(year <> $3, false))",
+			wantArgs: []interface{}{int64(1), int64(2), int64(3)},
+		},
+		{
+			filter:   " year:1959　",
+			wantSQL:  "year = $1",
+			wantArgs: []interface{}{int64(1959)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) returned error: %v", tt.filter, err)
+			}
+
+			args := queryArgs{}
+			got := expr.sql(&args)
+
+			if got != tt.wantSQL {
+				t.Errorf("SQL = %q; want %q", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual([]interface{}(args), tt.wantArgs) {
+				t.Errorf("args = %#v; want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	for _, filter := range []string{"", "   ", "\t\n"} {
+		expr, err := ParseFilter(filter)
+		if expr != nil || err != nil {
+			t.Errorf("ParseFilter(%q) = %v, %v; want nil, nil", filter, expr, err)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantPos int
+		wantMsg string
+	}{
+		{"label:blue", 1, `unknown field "label"`},
+		{"title", 6, `expected an operator after "title" but found end of filter`},
+		{"title:", 7, "expected a value but found end of filter"},
+		{"title>abc", 6, `operator ">" is not supported for title`},
+		{"genres~jazz", 7, `operator "~" is not supported for genres`},
+		{"year:19x9", 6, "year must be an integer"},
+		{"year:99999999999", 6, "year must be an integer"},
+		{`title:""`, 7, "title must not be empty"},
+		{`title:"open`, 7, "unterminated string"},
+		{"title!jazz", 6, `expected "=" after "!"`},
+		{"year:1 AND", 11, "expected a field name but found end of filter"},
+		{"year:1 year:2", 8, `unexpected "year"`},
+		{"(year:1", 8, `expected ")" but found end of filter`},
+		{"year:1)", 7, `unexpected ")"`},
+		{"title:all(a,b)", 7, `"all" is not supported for title`},
+		{"genres:any(jazz funk)", 17, `expected "," or ")" but found "funk"`},
+		{"genres:any()", 12, `expected a value but found ")"`},
+		{"year>any(1,2)", 5, "any(...) requires the : operator"},
+		{"title:voil\xe0", 11, "invalid UTF-8"},
+		{"title:\"caf\xc3\"", 11, "invalid UTF-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := ParseFilter(tt.filter)
+
+			var filterErr *FilterError
+			if !errors.As(err, &filterErr) {
+				t.Fatalf("ParseFilter(%q) error = %v; want a *FilterError", tt.filter, err)
+			}
+
+			if filterErr.Pos != tt.wantPos || filterErr.Msg != tt.wantMsg {
+				t.Errorf("error = %q at %d; want %q at %d", filterErr.Msg, filterErr.Pos, tt.wantMsg, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestParseFilterDepth(t *testing.T) {
+	nested := func(depth int, open, close string) string {
+		return strings.Repeat(open, depth) + "year:1" + strings.Repeat(close, depth)
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr bool
+	}{
+		{"parentheses at the limit", nested(maxFilterDepth, "(", ")"), false},
+		{"parentheses past the limit", nested(maxFilterDepth+1, "(", ")"), true},
+		{"NOT at the limit", nested(maxFilterDepth, "NOT ", ""), false},
+		{"NOT past the limit", nested(maxFilterDepth+1, "NOT ", ""), true},
+		{"mixed past the limit", nested(maxFilterDepth/2+1, "NOT (", ")"), true},
+		{"long flat chain", "year:1" + strings.Repeat(" OR year:1", 1000), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFilter(tt.filter)
+
+			switch {
+			case tt.wantErr && (err == nil || !strings.Contains(err.Error(), "nested too deeply")):
+				t.Errorf("error = %v; want a nesting error", err)
+			case !tt.wantErr && err != nil:
+				t.Errorf("error = %v; want nil", err)
+			}
+		})
+	}
+}
@@ -1,25 +1,40 @@
 package data
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"math"
 	"strings"
 
 	"github.com/davemolk/recordAPI/internal/validator"
 )
 
+const (
+	CountExact    = "exact"
+	CountEstimate = "estimate"
+	CountNone     = "none"
+)
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string
+	Count        string
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage     int    `json:"current_page,omitempty"`
+	PageSize        int    `json:"page_size,omitempty"`
+	FirstPage       int    `json:"first_page,omitempty"`
+	LastPage        int    `json:"last_page,omitempty"`
+	TotalRecords    int    `json:"total_records,omitempty"`
+	TotalIsEstimate bool   `json:"total_is_estimate,omitempty"`
+	NextCursor      string `json:"next_cursor,omitempty"`
+	PrevCursor      string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -59,6 +74,28 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+func (f Filters) countMode() string {
+	if f.Count == "" {
+		return CountExact
+	}
+	return f.Count
+}
+
+// FuzzyCursor reports whether the filters' cursor was issued for a fuzzy
+// search. It's false if there's no cursor or it isn't valid.
+func (f Filters) FuzzyCursor() bool {
+	if f.Cursor == "" {
+		return false
+	}
+
+	c, err := decodeCursor(f.Cursor)
+	if err != nil {
+		return false
+	}
+
+	return c.Fuzzy
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than 0")
 	v.Check(f.Page <= 500, "page", "maximum of 500")
@@ -66,4 +103,131 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "maximum of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(validator.PermittedValue(f.countMode(), CountExact, CountEstimate, CountNone), "count", "must be exact, estimate or none")
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+			return
+		}
+		v.Check(c.Sort == f.Sort, "cursor", "was issued for a different sort")
+	}
+}
+
+type queryArgs []interface{}
+
+func (q *queryArgs) add(arg interface{}) string {
+	*q = append(*q, arg)
+	return fmt.Sprintf("$%d", len(*q))
+}
+
+type keysetPage struct {
+	cursor    *cursor
+	condition string
+	orderBy   string
+	offset    int
+}
+
+// keyset works out how to fetch the page the filters ask for, ordered by
+// column (a column name or expression) in direction. With a cursor it seeks
+// past the cursor's (sort value, id) instead of using OFFSET, and walks the
+// index in reverse for a backward cursor. The id tie-breaker is always
+// ascending, matching the offset listing order.
+func (f Filters) keyset(args *queryArgs, column, direction string) (keysetPage, error) {
+	if f.Cursor == "" {
+		return keysetPage{
+			orderBy: fmt.Sprintf("%s %s, id ASC", column, direction),
+			offset:  f.offset(),
+		}, nil
+	}
+
+	c, err := decodeCursor(f.Cursor)
+	if err != nil {
+		return keysetPage{}, err
+	}
+
+	after, idAfter, idDirection := ">", ">", "ASC"
+	if direction == "DESC" {
+		after = "<"
+	}
+
+	if c.Backward {
+		after, idAfter = flipComparison(after), flipComparison(idAfter)
+		direction, idDirection = flipDirection(direction), flipDirection(idDirection)
+	}
+
+	value, id := args.add(c.Value), args.add(c.ID)
+
+	return keysetPage{
+		cursor:    c,
+		condition: fmt.Sprintf("AND (%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[4]s %[5]s))", column, after, value, idAfter, id),
+		orderBy:   fmt.Sprintf("%s %s, id %s", column, direction, idDirection),
+	}, nil
+}
+
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+func flipDirection(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// trimPage drops the extra row fetched to detect another page, puts rows
+// fetched by a backward cursor back in listing order, and reports whether
+// there are rows before and after the page.
+func trimPage[T any](page keysetPage, items []T, limit int) ([]T, bool, bool) {
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+
+	switch {
+	case page.cursor == nil:
+		return items, page.offset > 0, more
+	case page.cursor.Backward:
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+		return items, more, true
+	default:
+		return items, true, more
+	}
+}
+
+// countRows counts the rows matched by from (a FROM ... WHERE ... clause),
+// either exactly or from the planner's row estimate, which avoids scanning
+// every matching row.
+func countRows(ctx context.Context, db *sql.DB, mode, from string, args ...interface{}) (int, bool, error) {
+	if mode == CountExact {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT count(*) "+from, args...).Scan(&total)
+		return total, false, err
+	}
+
+	var js []byte
+	err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) SELECT 1 "+from, args...).Scan(&js)
+	if err != nil {
+		return 0, true, err
+	}
+
+	var plan []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+
+	err = json.Unmarshal(js, &plan)
+	if err != nil || len(plan) == 0 {
+		return 0, true, err
+	}
+
+	return int(plan[0].Plan.Rows), true, nil
 }
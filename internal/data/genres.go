@@ -0,0 +1,550 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateGenre      = errors.New("duplicate genre")
+	ErrGenreCycle          = errors.New("genre cycle")
+	ErrGenreInUse          = errors.New("genre in use")
+	ErrGenreParentNotFound = errors.New("genre parent not found")
+)
+
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	ParentID  *int64    `json:"parent_id"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+// GenreKey reduces a genre name to lowercase letters and digits, so "Hip-Hop",
+// "hip hop" and "hiphop" all share the key "hiphop". Genre names and aliases
+// must have distinct keys.
+func GenreKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// genreNames maps the key of every genre name and alias to the genre's
+// canonical name. It's filled by GenreModel.Load.
+var genreNames struct {
+	sync.RWMutex
+	byKey map[string]string
+}
+
+// LookupGenre returns the canonical name for a genre name or alias.
+func LookupGenre(name string) (string, bool) {
+	genreNames.RLock()
+	defer genreNames.RUnlock()
+
+	canonical, ok := genreNames.byKey[GenreKey(name)]
+	return canonical, ok
+}
+
+// NormalizeGenres replaces each genre with its canonical name, dropping any
+// that become duplicates, and returns the genres that aren't in the taxonomy.
+// Until the taxonomy has at least one genre, every genre is accepted as is.
+func NormalizeGenres(genres []string) ([]string, []string) {
+	genreNames.RLock()
+	defer genreNames.RUnlock()
+
+	if len(genreNames.byKey) == 0 {
+		return genres, nil
+	}
+
+	normalized := []string{}
+	seen := map[string]bool{}
+	var unknown []string
+
+	for _, genre := range genres {
+		canonical, ok := genreNames.byKey[GenreKey(genre)]
+		if !ok {
+			unknown = append(unknown, genre)
+			continue
+		}
+		if !seen[canonical] {
+			seen[canonical] = true
+			normalized = append(normalized, canonical)
+		}
+	}
+
+	return normalized, unknown
+}
+
+// genreWithDescendants returns an SQL array of the named genre (matched by key
+// or alias) and every genre beneath it in the taxonomy. The name itself is
+// always included, so genres missing from the taxonomy still match.
+func genreWithDescendants(args *queryArgs, genre string) string {
+	return fmt.Sprintf(`ARRAY(
+		WITH RECURSIVE tree AS (
+			SELECT id, name FROM genres
+			WHERE key = %[1]s OR id IN (SELECT genre_id FROM genre_aliases WHERE key = %[1]s)
+			UNION
+			SELECT g.id, g.name FROM genres g INNER JOIN tree ON g.parent_id = tree.id
+		)
+		SELECT name FROM tree
+		UNION
+		SELECT %[2]s::text)`, args.add(GenreKey(genre)), args.add(genre))
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(genre.Name == "" || GenreKey(genre.Name) != "", "name", "must contain a letter or digit")
+	v.Check(genre.ParentID == nil || *genre.ParentID != genre.ID, "parent_id", "must not be the genre itself")
+
+	keys := []string{GenreKey(genre.Name)}
+	for _, alias := range genre.Aliases {
+		v.Check(GenreKey(alias) != "", "aliases", "must contain a letter or digit")
+		v.Check(len(alias) <= 50, "aliases", "must not be more than 50 bytes long")
+		keys = append(keys, GenreKey(alias))
+	}
+	v.Check(validator.Unique(keys), "aliases", "must be distinct from each other and from the name")
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Load refreshes the in-memory copy of the taxonomy used to normalize album
+// genres.
+func (m GenreModel) Load() error {
+	query := `
+		SELECT key, name FROM genres
+		UNION ALL
+		SELECT a.key, g.name FROM genre_aliases a INNER JOIN genres g ON g.id = a.genre_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byKey := map[string]string{}
+
+	for rows.Next() {
+		var key, name string
+		err := rows.Scan(&key, &name)
+		if err != nil {
+			return err
+		}
+		byKey[key] = name
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	genreNames.Lock()
+	genreNames.byKey = byKey
+	genreNames.Unlock()
+
+	return nil
+}
+
+const genreColumns = `id, created_at, name, parent_id,
+	ARRAY(SELECT alias FROM genre_aliases WHERE genre_id = genres.id ORDER BY alias), version`
+
+func (m GenreModel) Insert(genre *Genre, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkGenreKeys(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO genres (name, key, parent_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, genre.Name, GenreKey(genre.Name), genre.ParentID).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		return genreWriteError(err)
+	}
+
+	err = replaceGenreAliases(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, "genre.create", "genre", genre.ID, genreChanges(&Genre{}, genre))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	query := `SELECT ` + genreColumns + ` FROM genres WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	genre, err := scanGenre(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return genre, nil
+}
+
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `SELECT ` + genreColumns + ` FROM genres ORDER BY name ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Update saves changes to a genre. Renaming a genre also renames it on every
+// album that uses it, and keeps the old name as an alias.
+func (m GenreModel) Update(genre *Genre, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + genreColumns + ` FROM genres WHERE id = $1 AND version = $2 FOR UPDATE`
+
+	old, err := scanGenre(tx.QueryRowContext(ctx, query, genre.ID, genre.Version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if old.Name != genre.Name && GenreKey(old.Name) != GenreKey(genre.Name) && !genreHasAlias(genre, old.Name) {
+		genre.Aliases = append(genre.Aliases, old.Name)
+	}
+
+	if genre.ParentID != nil {
+		query = `
+			WITH RECURSIVE tree AS (
+				SELECT id FROM genres WHERE id = $1
+				UNION
+				SELECT g.id FROM genres g INNER JOIN tree ON g.parent_id = tree.id
+			)
+			SELECT EXISTS (SELECT 1 FROM tree WHERE id = $2)`
+
+		var cycle bool
+		err = tx.QueryRowContext(ctx, query, genre.ID, *genre.ParentID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrGenreCycle
+		}
+	}
+
+	err = checkGenreKeys(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE genres
+		SET name = $1, key = $2, parent_id = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{genre.Name, GenreKey(genre.Name), genre.ParentID, genre.ID, genre.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return genreWriteError(err)
+	}
+
+	err = replaceGenreAliases(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	if old.Name != genre.Name {
+		err = renameAlbumGenre(ctx, tx, old.Name, genre.Name, actor)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "genre.update", "genre", genre.ID, genreChanges(old, genre))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a genre that no album uses. Its subgenres move up to its
+// parent.
+func (m GenreModel) Delete(id int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + genreColumns + ` FROM genres WHERE id = $1 FOR UPDATE`
+
+	old, err := scanGenre(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	var inUse bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM albums WHERE $1 = ANY(genres))`, old.Name).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrGenreInUse
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE genres SET parent_id = $1, version = version + 1 WHERE parent_id = $2`, old.ParentID, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, "genre.delete", "genre", id, genreChanges(old, &Genre{}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkGenreKeys makes sure the genre's name and aliases don't clash with
+// another genre's name or aliases. The unique constraints on genres.key and
+// genre_aliases.key can't catch a name clashing with an alias.
+func checkGenreKeys(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	aliasKeys := make([]string, len(genre.Aliases))
+	for i, alias := range genre.Aliases {
+		aliasKeys[i] = GenreKey(alias)
+	}
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM genre_aliases WHERE (key = $1 OR key = ANY($2)) AND genre_id <> $3)
+		OR EXISTS (SELECT 1 FROM genres WHERE key = ANY($2) AND id <> $3)`
+
+	var clash bool
+	err := tx.QueryRowContext(ctx, query, GenreKey(genre.Name), pq.Array(aliasKeys), genre.ID).Scan(&clash)
+	if err != nil {
+		return err
+	}
+	if clash {
+		return ErrDuplicateGenre
+	}
+
+	return nil
+}
+
+func replaceGenreAliases(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1`, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range genre.Aliases {
+		_, err = tx.ExecContext(ctx, `INSERT INTO genre_aliases (key, alias, genre_id) VALUES ($1, $2, $3)`, GenreKey(alias), alias, genre.ID)
+		if err != nil {
+			return genreWriteError(err)
+		}
+	}
+
+	return nil
+}
+
+// renameAlbumGenre replaces a genre on every album that has it, recording a
+// revision for each album changed.
+func renameAlbumGenre(ctx context.Context, tx *sql.Tx, oldName, newName string, actor Actor) error {
+	query := `
+		SELECT id, title, artist, genres, COALESCE(year, 0), version
+		FROM albums
+		WHERE $1 = ANY(genres)
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, oldName)
+	if err != nil {
+		return err
+	}
+
+	var albums []*Album
+
+	for rows.Next() {
+		var album Album
+		err := rows.Scan(&album.ID, &album.Title, &album.Artist, pq.Array(&album.Genres), &album.Year, &album.Version)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		albums = append(albums, &album)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, old := range albums {
+		album := *old
+		album.Genres = []string{}
+		for _, genre := range old.Genres {
+			if genre == oldName {
+				genre = newName
+			}
+			if !validator.PermittedValue(genre, album.Genres...) {
+				album.Genres = append(album.Genres, genre)
+			}
+		}
+
+		query := `
+			UPDATE albums
+			SET genres = $1, version = version + 1
+			WHERE id = $2
+			RETURNING version`
+
+		err := tx.QueryRowContext(ctx, query, pq.Array(album.Genres), album.ID).Scan(&album.Version)
+		if err != nil {
+			return err
+		}
+
+		err = writeAlbumRevision(ctx, tx, newAlbumRevision(&album, old, actor.UserID, RevisionUpdate), actor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func genreHasAlias(genre *Genre, name string) bool {
+	for _, alias := range genre.Aliases {
+		if GenreKey(alias) == GenreKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func genreWriteError(err error) error {
+	switch {
+	case strings.HasPrefix(err.Error(), `pq: duplicate key value violates unique constraint "genre`):
+		return ErrDuplicateGenre
+	case err.Error() == `pq: insert or update on table "genres" violates foreign key constraint "genres_parent_id_fkey"`:
+		return ErrGenreParentNotFound
+	default:
+		return err
+	}
+}
+
+func genreChanges(old, new *Genre) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.Name != new.Name {
+		changes["name"] = FieldChange{Old: nilIfEmpty(old.Name), New: nilIfEmpty(new.Name)}
+	}
+	if (old.ParentID == nil) != (new.ParentID == nil) || (old.ParentID != nil && *old.ParentID != *new.ParentID) {
+		changes["parent_id"] = FieldChange{Old: old.ParentID, New: new.ParentID}
+	}
+	if !equalStrings(old.Aliases, new.Aliases) {
+		changes["aliases"] = FieldChange{Old: old.Aliases, New: new.Aliases}
+	}
+
+	return changes
+}
+
+func scanGenre(row scanner) (*Genre, error) {
+	var genre Genre
+
+	err := row.Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Name,
+		&genre.ParentID,
+		pq.Array(&genre.Aliases),
+		&genre.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
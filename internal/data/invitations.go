@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+type Invitation struct {
+	Plaintext   string      `json:"token,omitempty"`
+	Hash        []byte      `json:"-"`
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"`
+	CreatedBy   int64       `json:"-"`
+	Expiry      time.Time   `json:"expiry"`
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "no duplicate values")
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+func (m InvitationModel) New(invitation *Invitation, ttl time.Duration, actor Actor) error {
+	var err error
+	invitation.Plaintext, invitation.Hash, err = generateTokenPlaintext()
+	if err != nil {
+		return err
+	}
+	invitation.Expiry = time.Now().Add(ttl)
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = Permissions{}
+	}
+
+	return m.Insert(invitation, actor)
+}
+
+func (m InvitationModel) Insert(invitation *Invitation, actor Actor) error {
+	query := `
+		INSERT INTO invitations (hash, email, permissions, created_by, expiry)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	args := []interface{}{
+		invitation.Hash,
+		invitation.Email,
+		pq.Array(invitation.Permissions),
+		invitation.CreatedBy,
+		invitation.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, "invitation.create", "invitation", 0, map[string]FieldChange{
+		"email":       {Old: nil, New: invitation.Email},
+		"permissions": {Old: nil, New: invitation.Permissions},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT hash, email, permissions, created_by, expiry
+		FROM invitations
+		WHERE hash = $1
+		AND expiry > $2`
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.Hash,
+		&invitation.Email,
+		pq.Array(&invitation.Permissions),
+		&invitation.CreatedBy,
+		&invitation.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Delete uses up an invitation. It returns ErrRecordNotFound if the
+// invitation has already been used.
+func (m InvitationModel) Delete(invitation *Invitation, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteInvitation(ctx, tx, invitation, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteInvitation(ctx context.Context, tx *sql.Tx, invitation *Invitation, actor Actor) error {
+	query := `
+		DELETE FROM invitations
+		WHERE hash = $1`
+
+	result, err := tx.ExecContext(ctx, query, invitation.Hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return writeAudit(ctx, tx, actor, "invitation.accept", "invitation", 0, map[string]FieldChange{
+		"email": {Old: invitation.Email, New: nil},
+	})
+}
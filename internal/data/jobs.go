@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job tracks a long-running task, such as an album import, started by a
+// request and run in the background.
+type Job struct {
+	ID        int64           `json:"id"`
+	UserID    *int64          `json:"-"`
+	Kind      string          `json:"kind"`
+	Status    string          `json:"status"`
+	Total     int             `json:"total"`
+	Processed int             `json:"processed"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+func (m JobModel) Insert(job *Job) error {
+	query := `
+		INSERT INTO jobs (user_id, kind, total)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, job.UserID, job.Kind, job.Total).Scan(&job.ID, &job.Status, &job.CreatedAt, &job.UpdatedAt)
+}
+
+// Get returns a job started by the given user.
+func (m JobModel) Get(id, userID int64) (*Job, error) {
+	query := `
+		SELECT id, user_id, kind, status, total, processed, result, error, created_at, updated_at
+		FROM jobs
+		WHERE id = $1 AND user_id = $2`
+
+	var job Job
+	var result []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Kind,
+		&job.Status,
+		&job.Total,
+		&job.Processed,
+		&result,
+		&job.Error,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	job.Result = result
+	return &job, nil
+}
+
+func (m JobModel) Start(id int64) error {
+	return m.exec(`UPDATE jobs SET status = $1, updated_at = NOW() WHERE id = $2`, JobRunning, id)
+}
+
+func (m JobModel) UpdateProgress(id int64, processed, total int) error {
+	return m.exec(`UPDATE jobs SET processed = $1, total = $2, updated_at = NOW() WHERE id = $3`, processed, total, id)
+}
+
+// Finish records a job's outcome: its result if it succeeded, or the error
+// that stopped it.
+func (m JobModel) Finish(id int64, result interface{}, jobErr error) error {
+	var js interface{}
+	if result != nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		js = b
+	}
+
+	status, message := JobSucceeded, ""
+	if jobErr != nil {
+		status, message = JobFailed, jobErr.Error()
+	}
+
+	return m.exec(`UPDATE jobs SET status = $1, result = $2, error = $3, updated_at = NOW() WHERE id = $4`, status, js, message, id)
+}
+
+func (m JobModel) exec(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
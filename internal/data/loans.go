@@ -0,0 +1,466 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+var ErrCopyOnLoan = errors.New("copy already on loan")
+
+var LoanStatusSafelist = []string{"all", "open", "overdue", "returned"}
+
+// Loan is a copy lent to someone. The borrower may be a registered user, in
+// which case BorrowerID is set and they're sent reminders too.
+type Loan struct {
+	ID              int64      `json:"id"`
+	CopyID          int64      `json:"copy_id"`
+	UserID          int64      `json:"-"`
+	Album           *Album     `json:"album,omitempty"`
+	BorrowerName    string     `json:"borrower_name"`
+	BorrowerContact string     `json:"borrower_contact,omitempty"`
+	BorrowerID      *int64     `json:"borrower_id,omitempty"`
+	LoanedAt        time.Time  `json:"loaned_at"`
+	DueAt           *time.Time `json:"due_at,omitempty"`
+	ReturnedAt      *time.Time `json:"returned_at,omitempty"`
+	Overdue         bool       `json:"overdue"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Version         int32      `json:"version"`
+}
+
+func ValidateLoan(v *validator.Validator, loan *Loan) {
+	v.Check(loan.CopyID > 0, "copy_id", "must be provided")
+	v.Check(loan.BorrowerName != "", "borrower_name", "must be provided")
+	v.Check(len(loan.BorrowerName) <= 100, "borrower_name", "must not be more than 100 bytes long")
+	v.Check(len(loan.BorrowerContact) <= 200, "borrower_contact", "must not be more than 200 bytes long")
+	v.Check(loan.LoanedAt.Year() >= 1900, "loaned_at", "must not be before 1900")
+	v.Check(loan.LoanedAt.Before(time.Now().Add(24*time.Hour)), "loaned_at", "must not be in the future")
+
+	if loan.DueAt != nil {
+		v.Check(loan.DueAt.After(loan.LoanedAt), "due_at", "must be after loaned_at")
+	}
+	if loan.ReturnedAt != nil {
+		v.Check(!loan.ReturnedAt.Before(loan.LoanedAt), "returned_at", "must not be before loaned_at")
+		v.Check(loan.ReturnedAt.Before(time.Now().Add(24*time.Hour)), "returned_at", "must not be in the future")
+	}
+}
+
+// OverdueLoan is an overdue loan with the details needed to remind its owner
+// and, if they're registered, its borrower.
+type OverdueLoan struct {
+	Loan
+	OwnerName     string
+	OwnerEmail    string
+	BorrowerEmail string
+}
+
+type LoanModel struct {
+	DB *sql.DB
+}
+
+const loanColumns = `
+	l.id, l.copy_id, l.user_id, l.borrower_name, l.borrower_contact, l.borrower_id,
+	l.loaned_at, l.due_at, l.returned_at, l.created_at, l.version,
+	a.id, a.title, a.artist, COALESCE(a.year, 0), a.version`
+
+const loanJoins = `
+	FROM loans l
+	INNER JOIN copies c ON c.id = l.copy_id
+	INNER JOIN albums a ON a.id = c.album_id`
+
+// loanStatusCondition returns the WHERE condition selecting loans with the
+// given status from LoanStatusSafelist.
+func loanStatusCondition(status string) (string, error) {
+	switch status {
+	case "open":
+		return "l.returned_at IS NULL", nil
+	case "overdue":
+		return "l.returned_at IS NULL AND l.due_at < NOW()", nil
+	case "returned":
+		return "l.returned_at IS NOT NULL", nil
+	case "all":
+		return "true", nil
+	default:
+		return "", fmt.Errorf("unknown loan status %q", status)
+	}
+}
+
+// Insert lends one of the user's copies. It returns ErrRecordNotFound if the
+// copy isn't theirs and ErrCopyOnLoan if it hasn't been returned from an
+// earlier loan.
+func (m LoanModel) Insert(loan *Loan, actor Actor) error {
+	query := `
+		INSERT INTO loans (copy_id, user_id, borrower_name, borrower_contact, borrower_id, loaned_at, due_at, returned_at)
+		SELECT id, user_id, $3, $4, $5, $6, $7, $8 FROM copies WHERE id = $1 AND user_id = $2
+		RETURNING id, created_at, version`
+
+	args := []interface{}{loan.CopyID, loan.UserID, loan.BorrowerName, loan.BorrowerContact, loan.BorrowerID, loan.LoanedAt, loan.DueAt, loan.ReturnedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&loan.ID, &loan.CreatedAt, &loan.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "loans_copy_id_open_idx"`:
+			return ErrCopyOnLoan
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "loan.create", "loan", loan.ID, loanChanges(&Loan{}, loan))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	loan.Overdue = loan.isOverdue(time.Now())
+	return nil
+}
+
+func (m LoanModel) Get(id, userID int64) (*Loan, error) {
+	query := `
+		SELECT ` + loanColumns + loanJoins + `
+		WHERE l.id = $1 AND l.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	loan, err := scanLoan(m.DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return loan, nil
+}
+
+// GetAllForUser returns the loans a user has made with the given status,
+// most recent first.
+func (m LoanModel) GetAllForUser(userID int64, status string, filters Filters) ([]*Loan, Metadata, error) {
+	return m.getAll("l.user_id", userID, status, filters)
+}
+
+// GetAllForBorrower returns the loans made to a registered user with the
+// given status, most recent first.
+func (m LoanModel) GetAllForBorrower(userID int64, status string, filters Filters) ([]*Loan, Metadata, error) {
+	return m.getAll("l.borrower_id", userID, status, filters)
+}
+
+func (m LoanModel) getAll(userColumn string, userID int64, status string, filters Filters) ([]*Loan, Metadata, error) {
+	condition, err := loanStatusCondition(status)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT `+loanColumns+`, count(*) OVER()`+loanJoins+`
+		WHERE %s = $1 AND %s
+		ORDER BY %s %s NULLS LAST, l.id DESC
+		LIMIT $2 OFFSET $3`, userColumn, condition, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	loans := []*Loan{}
+
+	for rows.Next() {
+		var total int
+
+		loan, err := scanLoan(rows, &total)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		totalRecords = total
+		loans = append(loans, loan)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return loans, metadata, nil
+}
+
+func (m LoanModel) Update(loan *Loan, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT borrower_name, borrower_contact, borrower_id, loaned_at, due_at, returned_at
+		FROM loans
+		WHERE id = $1 AND user_id = $2 AND version = $3
+		FOR UPDATE`
+
+	old := Loan{CopyID: loan.CopyID}
+
+	err = tx.QueryRowContext(ctx, query, loan.ID, loan.UserID, loan.Version).Scan(
+		&old.BorrowerName,
+		&old.BorrowerContact,
+		&old.BorrowerID,
+		&old.LoanedAt,
+		&old.DueAt,
+		&old.ReturnedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	// A new due date means the borrower should be reminded again once it
+	// passes.
+	query = `
+		UPDATE loans
+		SET borrower_name = $1, borrower_contact = $2, borrower_id = $3, loaned_at = $4, due_at = $5, returned_at = $6,
+			reminded_at = CASE WHEN due_at IS DISTINCT FROM $5 THEN NULL ELSE reminded_at END,
+			version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING version`
+
+	args := []interface{}{loan.BorrowerName, loan.BorrowerContact, loan.BorrowerID, loan.LoanedAt, loan.DueAt, loan.ReturnedAt, loan.ID, loan.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&loan.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "loan.update", "loan", loan.ID, loanChanges(&old, loan))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	loan.Overdue = loan.isOverdue(time.Now())
+	return nil
+}
+
+func (m LoanModel) Delete(id, userID int64, actor Actor) error {
+	query := `
+		DELETE FROM loans
+		WHERE id = $1 AND user_id = $2
+		RETURNING copy_id, borrower_name, borrower_contact, borrower_id, loaned_at, due_at, returned_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old Loan
+
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(
+		&old.CopyID,
+		&old.BorrowerName,
+		&old.BorrowerContact,
+		&old.BorrowerID,
+		&old.LoanedAt,
+		&old.DueAt,
+		&old.ReturnedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "loan.delete", "loan", id, loanChanges(&old, &Loan{}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetOverdueUnreminded returns overdue loans whose owner hasn't been
+// reminded since remindedBefore, oldest due date first.
+func (m LoanModel) GetOverdueUnreminded(remindedBefore time.Time, limit int) ([]*OverdueLoan, error) {
+	condition, err := loanStatusCondition("overdue")
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + loanColumns + `, o.name, o.email, COALESCE(b.email, '')` + loanJoins + `
+		INNER JOIN users o ON o.id = l.user_id
+		LEFT JOIN users b ON b.id = l.borrower_id AND b.activated
+		WHERE ` + condition + `
+		AND (l.reminded_at IS NULL OR l.reminded_at < $1)
+		AND a.deleted_at IS NULL
+		AND o.activated
+		ORDER BY l.due_at ASC, l.id ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, remindedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []*OverdueLoan{}
+
+	for rows.Next() {
+		var overdue OverdueLoan
+
+		loan, err := scanLoan(rows, &overdue.OwnerName, &overdue.OwnerEmail, &overdue.BorrowerEmail)
+		if err != nil {
+			return nil, err
+		}
+
+		overdue.Loan = *loan
+		loans = append(loans, &overdue)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return loans, nil
+}
+
+// MarkReminded records that reminders for the loan were sent.
+func (m LoanModel) MarkReminded(id int64) error {
+	query := `
+		UPDATE loans
+		SET reminded_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+func (l *Loan) isOverdue(now time.Time) bool {
+	return l.ReturnedAt == nil && l.DueAt != nil && l.DueAt.Before(now)
+}
+
+func loanChanges(old, new *Loan) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.CopyID != new.CopyID {
+		changes["copy_id"] = FieldChange{Old: nilIfZeroID(old.CopyID), New: nilIfZeroID(new.CopyID)}
+	}
+	if old.BorrowerName != new.BorrowerName {
+		changes["borrower_name"] = FieldChange{Old: nilIfEmpty(old.BorrowerName), New: nilIfEmpty(new.BorrowerName)}
+	}
+	if old.BorrowerContact != new.BorrowerContact {
+		changes["borrower_contact"] = FieldChange{Old: nilIfEmpty(old.BorrowerContact), New: nilIfEmpty(new.BorrowerContact)}
+	}
+	if !equalIDs(old.BorrowerID, new.BorrowerID) {
+		changes["borrower_id"] = FieldChange{Old: nilIfNoID(old.BorrowerID), New: nilIfNoID(new.BorrowerID)}
+	}
+	if !old.LoanedAt.Equal(new.LoanedAt) {
+		changes["loaned_at"] = FieldChange{Old: nilIfNoTime(&old.LoanedAt), New: nilIfNoTime(&new.LoanedAt)}
+	}
+	if !equalTimes(old.DueAt, new.DueAt) {
+		changes["due_at"] = FieldChange{Old: nilIfNoTime(old.DueAt), New: nilIfNoTime(new.DueAt)}
+	}
+	if !equalTimes(old.ReturnedAt, new.ReturnedAt) {
+		changes["returned_at"] = FieldChange{Old: nilIfNoTime(old.ReturnedAt), New: nilIfNoTime(new.ReturnedAt)}
+	}
+
+	return changes
+}
+
+func nilIfNoID(id *int64) interface{} {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+func equalIDs(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func scanLoan(row scanner, extra ...interface{}) (*Loan, error) {
+	loan := Loan{Album: &Album{}}
+
+	dest := append([]interface{}{
+		&loan.ID,
+		&loan.CopyID,
+		&loan.UserID,
+		&loan.BorrowerName,
+		&loan.BorrowerContact,
+		&loan.BorrowerID,
+		&loan.LoanedAt,
+		&loan.DueAt,
+		&loan.ReturnedAt,
+		&loan.CreatedAt,
+		&loan.Version,
+		&loan.Album.ID,
+		&loan.Album.Title,
+		&loan.Album.Artist,
+		&loan.Album.Year,
+		&loan.Album.Version,
+	}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.Overdue = loan.isOverdue(time.Now())
+
+	return &loan, nil
+}
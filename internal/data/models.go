@@ -11,17 +11,47 @@ var (
 )
 
 type Models struct {
-	Albums AlbumModel
-	Permissions PermissionModel
-	Tokens TokenModel
-	Users  UserModel
+	Albums         AlbumModel
+	AlbumRevisions AlbumRevisionModel
+	AlbumSources   AlbumSourceModel
+	Audit          AuditModel
+	Copies         CopyModel
+	ExchangeRates  ExchangeRateModel
+	Genres         GenreModel
+	Invitations    InvitationModel
+	Jobs           JobModel
+	Loans          LoanModel
+	Permissions    PermissionModel
+	Plays          PlayModel
+	Prices         PriceModel
+	Privacy        PrivacyModel
+	SavedSearches  SavedSearchModel
+	ShareLinks     ShareLinkModel
+	Tags           TagModel
+	Tokens         TokenModel
+	Users          UserModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Albums: AlbumModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens: TokenModel{DB: db},
-		Users:  UserModel{DB: db},
+		Albums:         AlbumModel{DB: db},
+		AlbumRevisions: AlbumRevisionModel{DB: db},
+		AlbumSources:   AlbumSourceModel{DB: db},
+		Audit:          AuditModel{DB: db},
+		Copies:         CopyModel{DB: db},
+		ExchangeRates:  ExchangeRateModel{DB: db},
+		Genres:         GenreModel{DB: db},
+		Invitations:    InvitationModel{DB: db},
+		Jobs:           JobModel{DB: db},
+		Loans:          LoanModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Plays:          PlayModel{DB: db},
+		Prices:         PriceModel{DB: db},
+		Privacy:        PrivacyModel{DB: db},
+		SavedSearches:  SavedSearchModel{DB: db},
+		ShareLinks:     ShareLinkModel{DB: db},
+		Tags:           TagModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Users:          UserModel{DB: db},
 	}
 }
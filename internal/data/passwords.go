@@ -0,0 +1,212 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownHashAlgorithm = errors.New("unknown password hash algorithm")
+	ErrInvalidHash          = errors.New("invalid password hash")
+)
+
+// PasswordHasher produces and verifies self-describing encoded password
+// hashes. The encoded form records the algorithm and its parameters so a
+// stored hash can always be checked, and rehashed when the parameters change.
+type PasswordHasher interface {
+	Algorithm() string
+	MaxPasswordLength() int
+	Hash(plaintext string) ([]byte, error)
+	Identifies(hash []byte) bool
+	Matches(plaintext string, hash []byte) (bool, error)
+	NeedsRehash(hash []byte) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Algorithm() string {
+	return "bcrypt"
+}
+
+func (h BcryptHasher) MaxPasswordLength() int {
+	return 72
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) ||
+		bytes.HasPrefix(hash, []byte("$2b$")) ||
+		bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (h BcryptHasher) Matches(plaintext string, hash []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return true
+	}
+	return cost != h.Cost
+}
+
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var DefaultArgon2idHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (h Argon2idHasher) Algorithm() string {
+	return "argon2id"
+}
+
+func (h Argon2idHasher) MaxPasswordLength() int {
+	return 1024
+}
+
+// Hash encodes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Memory,
+		h.Iterations,
+		h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func (h Argon2idHasher) Matches(plaintext string, hash []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, salt, _, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		params.KeyLength != h.KeyLength ||
+		uint32(len(salt)) != h.SaltLength
+}
+
+func decodeArgon2idHash(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, ErrUnknownHashAlgorithm
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+var passwordHashers = struct {
+	current PasswordHasher
+	known   []PasswordHasher
+}{
+	current: DefaultArgon2idHasher,
+	known:   []PasswordHasher{DefaultArgon2idHasher, BcryptHasher{Cost: 12}},
+}
+
+// SetPasswordHasher makes h the hasher used for new passwords. Hashers for
+// any other algorithm still present in the users table should be passed as
+// legacy so existing hashes can be verified and upgraded on login.
+func SetPasswordHasher(h PasswordHasher, legacy ...PasswordHasher) {
+	passwordHashers.current = h
+	passwordHashers.known = append([]PasswordHasher{h}, legacy...)
+}
+
+func hasherFor(hash []byte) (PasswordHasher, error) {
+	for _, h := range passwordHashers.known {
+		if h.Identifies(hash) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownHashAlgorithm
+}
@@ -59,14 +59,35 @@ func (p PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (p PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (p PermissionModel) AddForUser(userID int64, actor Actor, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addUserPermissions(ctx, tx, userID, actor, codes...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func addUserPermissions(ctx context.Context, tx *sql.Tx, userID int64, actor Actor, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
 
-	_, err := p.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
-}
\ No newline at end of file
+	return writeAudit(ctx, tx, actor, "permission.grant", "user", userID, map[string]FieldChange{
+		"permissions": {Old: nil, New: codes},
+	})
+}
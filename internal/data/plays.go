@@ -0,0 +1,316 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicatePlay = errors.New("duplicate play")
+
+var TopPlaysSafelist = []string{"albums", "artists", "genres"}
+
+type Play struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	AlbumID   int64     `json:"album_id"`
+	Track     string    `json:"track,omitempty"`
+	PlayedAt  time.Time `json:"played_at"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"-"`
+}
+
+func ValidatePlay(v *validator.Validator, play *Play) {
+	v.Check(play.AlbumID > 0, "album_id", "must be provided")
+	v.Check(len(play.Track) <= 200, "track", "must not be more than 200 bytes long")
+	v.Check(play.Source != "", "source", "must be provided")
+	v.Check(len(play.Source) <= 50, "source", "must not be more than 50 bytes long")
+	v.Check(play.PlayedAt.Year() >= 1900, "played_at", "must not be before 1900")
+	// Allow for clients whose clocks run a little fast.
+	v.Check(play.PlayedAt.Before(time.Now().Add(5*time.Minute)), "played_at", "must not be in the future")
+}
+
+type PlayFilters struct {
+	AlbumID int64
+	From    *time.Time
+	To      *time.Time
+}
+
+// PlayCount is an album, artist or genre with how often a user played it.
+// AlbumID and Artist are only set when counting albums.
+type PlayCount struct {
+	Name         string    `json:"name"`
+	AlbumID      int64     `json:"album_id,omitempty"`
+	Artist       string    `json:"artist,omitempty"`
+	Plays        int       `json:"plays"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+// Streak is a run of consecutive days with at least one play.
+type Streak struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Days  int       `json:"days"`
+}
+
+type PlayModel struct {
+	DB *sql.DB
+}
+
+func (m PlayModel) Insert(play *Play) error {
+	query := `
+		INSERT INTO plays (user_id, album_id, track, played_at, source)
+		SELECT $1, id, $3, $4, $5 FROM albums WHERE id = $2 AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{play.UserID, play.AlbumID, play.Track, play.PlayedAt, play.Source}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&play.ID, &play.CreatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// Nothing was inserted, either because the album doesn't exist or
+		// because the play was already recorded.
+		var exists bool
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM albums WHERE id = $1 AND deleted_at IS NULL)`, play.AlbumID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRecordNotFound
+		}
+		return ErrDuplicatePlay
+	}
+
+	return nil
+}
+
+// InsertMany records plays submitted together, such as by a client that was
+// offline, skipping any already recorded. It returns the number inserted.
+// Every play's album must exist; see AlbumModel.Missing.
+func (m PlayModel) InsertMany(plays []*Play) (int, error) {
+	if len(plays) == 0 {
+		return 0, nil
+	}
+
+	userIDs := make([]int64, len(plays))
+	albumIDs := make([]int64, len(plays))
+	tracks := make([]string, len(plays))
+	playedAt := make([]string, len(plays))
+	sources := make([]string, len(plays))
+
+	for i, play := range plays {
+		userIDs[i] = play.UserID
+		albumIDs[i] = play.AlbumID
+		tracks[i] = play.Track
+		playedAt[i] = play.PlayedAt.Format(time.RFC3339)
+		sources[i] = play.Source
+	}
+
+	query := `
+		INSERT INTO plays (user_id, album_id, track, played_at, source)
+		SELECT * FROM unnest($1::bigint[], $2::bigint[], $3::text[], $4::timestamptz[], $5::text[])
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, pq.Array(userIDs), pq.Array(albumIDs), pq.Array(tracks), pq.Array(playedAt), pq.Array(sources))
+	if err != nil {
+		return 0, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(inserted), nil
+}
+
+func (m PlayModel) GetAllForUser(userID int64, playFilters PlayFilters, filters Filters) ([]*Play, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, album_id, track, played_at, source
+		FROM plays
+		WHERE user_id = $1
+		AND (album_id = $2 OR $2 = 0)
+		AND ($3::timestamptz IS NULL OR played_at >= $3)
+		AND ($4::timestamptz IS NULL OR played_at < $4)
+		ORDER BY %s %s, id DESC
+		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userID, playFilters.AlbumID, playFilters.From, playFilters.To, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	plays := []*Play{}
+
+	for rows.Next() {
+		play := Play{UserID: userID}
+		err := rows.Scan(&totalRecords, &play.ID, &play.AlbumID, &play.Track, &play.PlayedAt, &play.Source)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		plays = append(plays, &play)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return plays, metadata, nil
+}
+
+// Top returns the albums, artists or genres (see TopPlaysSafelist) a user
+// played most within the filters' time window.
+func (m PlayModel) Top(userID int64, by string, playFilters PlayFilters, limit int) ([]*PlayCount, error) {
+	var selectName, from, groupBy string
+
+	switch by {
+	case "albums":
+		selectName, from, groupBy = "a.title, a.id, a.artist", "albums a", "a.id"
+	case "artists":
+		selectName, from, groupBy = "a.artist, 0, ''", "albums a", "a.artist"
+	case "genres":
+		selectName, from, groupBy = "g.genre, 0, ''", "albums a CROSS JOIN unnest(a.genres) AS g(genre)", "g.genre"
+	default:
+		return nil, fmt.Errorf("unknown play count %q", by)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, count(*), max(p.played_at)
+		FROM plays p
+		INNER JOIN %s ON a.id = p.album_id
+		WHERE p.user_id = $1 AND a.deleted_at IS NULL
+		AND ($2::timestamptz IS NULL OR p.played_at >= $2)
+		AND ($3::timestamptz IS NULL OR p.played_at < $3)
+		GROUP BY %s
+		ORDER BY count(*) DESC, max(p.played_at) DESC
+		LIMIT $4`, selectName, from, groupBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, playFilters.From, playFilters.To, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []*PlayCount{}
+
+	for rows.Next() {
+		var count PlayCount
+		err := rows.Scan(&count.Name, &count.AlbumID, &count.Artist, &count.Plays, &count.LastPlayedAt)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// Streaks returns a user's runs of consecutive listening days, counting days
+// in the given time zone, most recent first.
+func (m PlayModel) Streaks(userID int64, timeZone string) ([]*Streak, error) {
+	query := `
+		WITH days AS (
+			SELECT DISTINCT (played_at AT TIME ZONE $2)::date AS day
+			FROM plays
+			WHERE user_id = $1
+		), runs AS (
+			SELECT day, day - (row_number() OVER (ORDER BY day))::integer AS run
+			FROM days
+		)
+		SELECT min(day), max(day), count(*)
+		FROM runs
+		GROUP BY run
+		ORDER BY max(day) DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, timeZone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streaks := []*Streak{}
+
+	for rows.Next() {
+		var streak Streak
+		err := rows.Scan(&streak.Start, &streak.End, &streak.Days)
+		if err != nil {
+			return nil, err
+		}
+		streaks = append(streaks, &streak)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return streaks, nil
+}
+
+// Forgotten returns albums a user has played, but not since before, least
+// recently played first.
+func (m PlayModel) Forgotten(userID int64, before time.Time, limit int) ([]*PlayCount, error) {
+	query := `
+		SELECT a.title, a.id, a.artist, count(*), max(p.played_at)
+		FROM plays p
+		INNER JOIN albums a ON a.id = p.album_id
+		WHERE p.user_id = $1 AND a.deleted_at IS NULL
+		GROUP BY a.id
+		HAVING max(p.played_at) < $2
+		ORDER BY max(p.played_at) ASC, a.id ASC
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []*PlayCount{}
+
+	for rows.Next() {
+		var count PlayCount
+		err := rows.Scan(&count.Name, &count.AlbumID, &count.Artist, &count.Plays, &count.LastPlayedAt)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,332 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Price is an observed price for an album, such as a marketplace sale. An
+// empty Condition means the condition wasn't recorded.
+type Price struct {
+	ID         int64     `json:"id"`
+	AlbumID    int64     `json:"album_id"`
+	Source     string    `json:"source"`
+	Condition  string    `json:"condition,omitempty"`
+	Currency   string    `json:"currency"`
+	Amount     float64   `json:"amount"`
+	ObservedAt time.Time `json:"observed_at"`
+	CreatedAt  time.Time `json:"-"`
+}
+
+func ValidatePrice(v *validator.Validator, price *Price) {
+	v.Check(price.AlbumID > 0, "album_id", "must be provided")
+	v.Check(price.Source != "", "source", "must be provided")
+	v.Check(len(price.Source) <= 100, "source", "must not be more than 100 bytes long")
+	v.Check(price.Condition == "" || validator.PermittedValue(price.Condition, CopyConditions...), "condition", "must be one of M, NM, VG+, VG, G+, G, F or P")
+	v.Check(validator.Matches(price.Currency, validator.CurrencyRX), "currency", "must be a three-letter ISO 4217 code, such as USD")
+	v.Check(price.Amount > 0, "amount", "must be greater than zero")
+	v.Check(price.Amount < 1e9, "amount", "must be less than 1000000000")
+	v.Check(price.ObservedAt.Year() >= 1900, "observed_at", "must not be before 1900")
+	v.Check(price.ObservedAt.Before(time.Now().Add(24*time.Hour)), "observed_at", "must not be in the future")
+}
+
+// CopyPrices is a copy in a user's collection with the most recent prices
+// observed for its album in the copy's condition, newest first. A copy with
+// no recorded condition matches prices in any condition.
+type CopyPrices struct {
+	Copy   *Copy
+	Prices []*Price
+}
+
+type PriceModel struct {
+	DB *sql.DB
+}
+
+func (m PriceModel) Insert(price *Price, actor Actor) error {
+	query := `
+		INSERT INTO price_observations (album_id, source, condition, currency, amount, observed_at)
+		SELECT id, $2, $3, $4, $5, $6 FROM albums WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, created_at`
+
+	args := []interface{}{price.AlbumID, price.Source, price.Condition, price.Currency, price.Amount, price.ObservedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&price.ID, &price.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "price.create", "price", price.ID, priceChanges(&Price{}, price))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertMany records prices in bulk, such as from an import file, with a
+// single audit entry. Every price's album must exist; see AlbumModel.Missing.
+func (m PriceModel) InsertMany(prices []*Price, actor Actor) (int, error) {
+	if len(prices) == 0 {
+		return 0, nil
+	}
+
+	albumIDs := make([]int64, len(prices))
+	sources := make([]string, len(prices))
+	conditions := make([]string, len(prices))
+	currencies := make([]string, len(prices))
+	amounts := make([]float64, len(prices))
+	observedAt := make([]string, len(prices))
+
+	for i, price := range prices {
+		albumIDs[i] = price.AlbumID
+		sources[i] = price.Source
+		conditions[i] = price.Condition
+		currencies[i] = price.Currency
+		amounts[i] = price.Amount
+		observedAt[i] = price.ObservedAt.Format(time.RFC3339)
+	}
+
+	query := `
+		INSERT INTO price_observations (album_id, source, condition, currency, amount, observed_at)
+		SELECT * FROM unnest($1::bigint[], $2::text[], $3::text[], $4::text[], $5::numeric[], $6::timestamptz[])`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{pq.Array(albumIDs), pq.Array(sources), pq.Array(conditions), pq.Array(currencies), pq.Array(amounts), pq.Array(observedAt)}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	err = writeAudit(ctx, tx, actor, "price.import", "price", 0, map[string]FieldChange{
+		"count": {Old: nil, New: inserted},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(inserted), tx.Commit()
+}
+
+// GetAllForAlbum returns the prices observed for an album, optionally only
+// those in one condition or currency.
+func (m PriceModel) GetAllForAlbum(albumID int64, condition, currency string, filters Filters) ([]*Price, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, album_id, source, condition, currency, amount, observed_at, created_at
+		FROM price_observations
+		WHERE album_id = $1
+		AND (condition = $2 OR $2 = '')
+		AND (currency = $3 OR $3 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, albumID, condition, currency, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	prices := []*Price{}
+
+	for rows.Next() {
+		var price Price
+
+		err := rows.Scan(
+			&totalRecords,
+			&price.ID,
+			&price.AlbumID,
+			&price.Source,
+			&price.Condition,
+			&price.Currency,
+			&price.Amount,
+			&price.ObservedAt,
+			&price.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		prices = append(prices, &price)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return prices, metadata, nil
+}
+
+func (m PriceModel) Delete(id int64, actor Actor) error {
+	query := `
+		DELETE FROM price_observations
+		WHERE id = $1
+		RETURNING album_id, source, condition, currency, amount, observed_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old Price
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&old.AlbumID, &old.Source, &old.Condition, &old.Currency, &old.Amount, &old.ObservedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "price.delete", "price", id, priceChanges(&old, &Price{}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetForCollection returns every copy in a user's collection with up to
+// perCopy of the prices observed since since for it. Copies of trashed
+// albums are left out.
+func (m PriceModel) GetForCollection(userID int64, since time.Time, perCopy int) ([]*CopyPrices, error) {
+	query := `
+		SELECT ` + copyColumns + `,
+			p.id, p.source, p.condition, p.currency, p.amount, p.observed_at
+		FROM copies c
+		INNER JOIN albums a ON a.id = c.album_id
+		LEFT JOIN LATERAL (
+			SELECT id, source, condition, currency, amount, observed_at
+			FROM price_observations
+			WHERE album_id = c.album_id
+			AND (condition = c.condition OR c.condition = '')
+			AND observed_at >= $2
+			ORDER BY observed_at DESC, id DESC
+			LIMIT $3
+		) p ON true
+		WHERE c.user_id = $1 AND a.deleted_at IS NULL
+		ORDER BY c.id ASC, p.observed_at DESC, p.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, since, perCopy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collection := []*CopyPrices{}
+
+	for rows.Next() {
+		var (
+			priceID    sql.NullInt64
+			source     sql.NullString
+			condition  sql.NullString
+			currency   sql.NullString
+			amount     sql.NullFloat64
+			observedAt sql.NullTime
+		)
+
+		copy, err := scanCopy(rows, &priceID, &source, &condition, &currency, &amount, &observedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(collection); n == 0 || collection[n-1].Copy.ID != copy.ID {
+			collection = append(collection, &CopyPrices{Copy: copy, Prices: []*Price{}})
+		}
+
+		if priceID.Valid {
+			entry := collection[len(collection)-1]
+			entry.Prices = append(entry.Prices, &Price{
+				ID:         priceID.Int64,
+				AlbumID:    copy.AlbumID,
+				Source:     source.String,
+				Condition:  condition.String,
+				Currency:   currency.String,
+				Amount:     amount.Float64,
+				ObservedAt: observedAt.Time,
+			})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func priceChanges(old, new *Price) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.AlbumID != new.AlbumID {
+		changes["album_id"] = FieldChange{Old: nilIfZeroID(old.AlbumID), New: nilIfZeroID(new.AlbumID)}
+	}
+	if old.Source != new.Source {
+		changes["source"] = FieldChange{Old: nilIfEmpty(old.Source), New: nilIfEmpty(new.Source)}
+	}
+	if old.Condition != new.Condition {
+		changes["condition"] = FieldChange{Old: nilIfEmpty(old.Condition), New: nilIfEmpty(new.Condition)}
+	}
+	if old.Currency != new.Currency {
+		changes["currency"] = FieldChange{Old: nilIfEmpty(old.Currency), New: nilIfEmpty(new.Currency)}
+	}
+	if old.Amount != new.Amount {
+		changes["amount"] = FieldChange{Old: nilIfNoAmount(old.Amount), New: nilIfNoAmount(new.Amount)}
+	}
+	if !old.ObservedAt.Equal(new.ObservedAt) {
+		changes["observed_at"] = FieldChange{Old: nilIfNoTime(&old.ObservedAt), New: nilIfNoTime(&new.ObservedAt)}
+	}
+
+	return changes
+}
+
+func nilIfNoAmount(amount float64) interface{} {
+	if amount == 0 {
+		return nil
+	}
+	return amount
+}
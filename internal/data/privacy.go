@@ -0,0 +1,338 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+var (
+	ErrDuplicateSlug = errors.New("duplicate slug")
+
+	SlugRX = regexp.MustCompile("^[a-z0-9](?:[a-z0-9-]{1,38}[a-z0-9])$")
+)
+
+// Privacy holds a user's privacy settings. A public collection is listed at
+// /v1/public/:slug/collection, with the user's name if ShowName is set. A
+// user without settings has a private collection and no slug.
+type Privacy struct {
+	UserID           int64     `json:"-"`
+	Slug             string    `json:"slug"`
+	CollectionPublic bool      `json:"collection_public"`
+	ShowName         bool      `json:"show_name"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Version          int32     `json:"version"`
+	Name             string    `json:"-"`
+}
+
+func ValidatePrivacy(v *validator.Validator, privacy *Privacy) {
+	v.Check(privacy.Slug == "" || validator.Matches(privacy.Slug, SlugRX), "slug", "must be 3 to 40 lowercase letters, digits or hyphens, starting and ending with a letter or digit")
+	v.Check(privacy.Slug != "" || !privacy.CollectionPublic, "slug", "must be provided to make your collection public")
+}
+
+// ShareLink is a secret link to a user's collection, which works whether or
+// not the collection is public until it expires or is revoked. Plaintext is
+// only known when the link is created.
+type ShareLink struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Plaintext  string     `json:"token,omitempty"`
+	Hash       []byte     `json:"-"`
+	Name       string     `json:"name,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func ValidateShareLink(v *validator.Validator, link *ShareLink) {
+	v.Check(len(link.Name) <= 100, "name", "must not be more than 100 bytes long")
+	if link.ExpiresAt != nil {
+		v.Check(link.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+type PrivacyModel struct {
+	DB *sql.DB
+}
+
+// Get returns the user's privacy settings, or the defaults if they haven't
+// changed them.
+func (m PrivacyModel) Get(userID int64) (*Privacy, error) {
+	query := `
+		SELECT COALESCE(slug, ''), collection_public, show_name, updated_at, version
+		FROM user_privacy
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	privacy := Privacy{UserID: userID}
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&privacy.Slug, &privacy.CollectionPublic, &privacy.ShowName, &privacy.UpdatedAt, &privacy.Version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &privacy, nil
+}
+
+// GetPublic returns the settings of the user with the given slug, with their
+// name, if their collection is public.
+func (m PrivacyModel) GetPublic(slug string) (*Privacy, error) {
+	query := `
+		SELECT p.user_id, p.slug, p.collection_public, p.show_name, p.updated_at, p.version, u.name
+		FROM user_privacy p
+		INNER JOIN users u ON u.id = p.user_id
+		WHERE p.slug = $1 AND p.collection_public AND u.activated`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var privacy Privacy
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&privacy.UserID,
+		&privacy.Slug,
+		&privacy.CollectionPublic,
+		&privacy.ShowName,
+		&privacy.UpdatedAt,
+		&privacy.Version,
+		&privacy.Name,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &privacy, nil
+}
+
+// Update saves the user's privacy settings. Settings that have never been
+// saved have version 0.
+func (m PrivacyModel) Update(privacy *Privacy, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT COALESCE(slug, ''), collection_public, show_name, version
+		FROM user_privacy
+		WHERE user_id = $1
+		FOR UPDATE`
+
+	var old Privacy
+
+	err = tx.QueryRowContext(ctx, query, privacy.UserID).Scan(&old.Slug, &old.CollectionPublic, &old.ShowName, &old.Version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if old.Version != privacy.Version {
+		return ErrEditConflict
+	}
+
+	query = `
+		INSERT INTO user_privacy (user_id, slug, collection_public, show_name)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET slug = EXCLUDED.slug, collection_public = EXCLUDED.collection_public, show_name = EXCLUDED.show_name,
+			updated_at = NOW(), version = user_privacy.version + 1
+		RETURNING updated_at, version`
+
+	args := []interface{}{privacy.UserID, privacy.Slug, privacy.CollectionPublic, privacy.ShowName}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&privacy.UpdatedAt, &privacy.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_privacy_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "privacy.update", "user", privacy.UserID, privacyChanges(&old, privacy))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func privacyChanges(old, new *Privacy) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.Slug != new.Slug {
+		changes["slug"] = FieldChange{Old: nilIfEmpty(old.Slug), New: nilIfEmpty(new.Slug)}
+	}
+	if old.CollectionPublic != new.CollectionPublic {
+		changes["collection_public"] = FieldChange{Old: old.CollectionPublic, New: new.CollectionPublic}
+	}
+	if old.ShowName != new.ShowName {
+		changes["show_name"] = FieldChange{Old: old.ShowName, New: new.ShowName}
+	}
+
+	return changes
+}
+
+type ShareLinkModel struct {
+	DB *sql.DB
+}
+
+func (m ShareLinkModel) New(link *ShareLink, actor Actor) error {
+	var err error
+	link.Plaintext, link.Hash, err = generateTokenPlaintext()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO share_links (user_id, hash, name, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []interface{}{link.UserID, link.Hash, link.Name, link.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, "share_link.create", "share_link", link.ID, map[string]FieldChange{
+		"name":       {Old: nil, New: nilIfEmpty(link.Name)},
+		"expires_at": {Old: nil, New: nilIfNoTime(link.ExpiresAt)},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ShareLinkModel) GetAllForUser(userID int64) ([]*ShareLink, error) {
+	query := `
+		SELECT id, user_id, name, created_at, expires_at, revoked_at, last_used_at
+		FROM share_links
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+
+	for rows.Next() {
+		var link ShareLink
+
+		err := rows.Scan(&link.ID, &link.UserID, &link.Name, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt, &link.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, &link)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// Revoke stops a share link from working. Revoked links are kept so the
+// user can see when they were used.
+func (m ShareLinkModel) Revoke(id, userID int64, actor Actor) error {
+	query := `
+		UPDATE share_links
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		RETURNING revoked_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var revokedAt time.Time
+
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&revokedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "share_link.revoke", "share_link", id, map[string]FieldChange{
+		"revoked_at": {Old: nil, New: revokedAt},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Use returns the ID of the user who shared their collection with the given
+// link, if it's still valid, and records that it was used.
+func (m ShareLinkModel) Use(tokenPlaintext string) (int64, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE share_links
+		SET last_used_at = NOW()
+		WHERE hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,314 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+var ErrDuplicateSavedSearch = errors.New("duplicate saved search")
+
+// SavedSearch is a named album listing query. Query holds the listing's URL
+// query parameters, encoded. Subscribed searches are emailed a digest of
+// albums added after LastAlbumID.
+type SavedSearch struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Query       string    `json:"query"`
+	Subscribed  bool      `json:"subscribed"`
+	LastAlbumID int64     `json:"-"`
+	Version     int32     `json:"version"`
+	Email       string    `json:"-"`
+}
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch) {
+	v.Check(search.Name != "", "name", "must be provided")
+	v.Check(len(search.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(search.Query) <= 2000, "query", "must not be more than 2000 bytes long")
+}
+
+type SavedSearchModel struct {
+	DB *sql.DB
+}
+
+// latestAlbumID is the starting point for a new digest subscription, so only
+// albums added after subscribing are sent.
+const latestAlbumID = `(SELECT COALESCE(max(id), 0) FROM albums)`
+
+func (m SavedSearchModel) Insert(search *SavedSearch, actor Actor) error {
+	query := `
+		INSERT INTO saved_searches (user_id, name, query, subscribed, last_album_id)
+		VALUES ($1, $2, $3, $4, ` + latestAlbumID + `)
+		RETURNING id, created_at, last_album_id, version`
+
+	args := []interface{}{search.UserID, search.Name, search.Query, search.Subscribed}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&search.ID, &search.CreatedAt, &search.LastAlbumID, &search.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "saved_searches_user_id_name_key"`:
+			return ErrDuplicateSavedSearch
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "saved_search.create", "saved_search", search.ID, savedSearchChanges(&SavedSearch{}, search))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m SavedSearchModel) Get(id, userID int64) (*SavedSearch, error) {
+	query := `
+		SELECT id, user_id, created_at, name, query, subscribed, last_album_id, version
+		FROM saved_searches
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	search, err := scanSavedSearch(m.DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return search, nil
+}
+
+func (m SavedSearchModel) GetAllForUser(userID int64) ([]*SavedSearch, error) {
+	query := `
+		SELECT id, user_id, created_at, name, query, subscribed, last_album_id, version
+		FROM saved_searches
+		WHERE user_id = $1
+		ORDER BY name ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, search)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+// GetAllSubscribed returns every subscribed search belonging to an activated
+// user, with the user's email address.
+func (m SavedSearchModel) GetAllSubscribed() ([]*SavedSearch, error) {
+	query := `
+		SELECT s.id, s.user_id, s.created_at, s.name, s.query, s.subscribed, s.last_album_id, s.version, u.email
+		FROM saved_searches s
+		INNER JOIN users u ON u.id = s.user_id
+		WHERE s.subscribed AND u.activated
+		ORDER BY s.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+
+	for rows.Next() {
+		var email string
+
+		search, err := scanSavedSearch(rows, &email)
+		if err != nil {
+			return nil, err
+		}
+
+		search.Email = email
+		searches = append(searches, search)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+func (m SavedSearchModel) Update(search *SavedSearch, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT name, query, subscribed
+		FROM saved_searches
+		WHERE id = $1 AND user_id = $2 AND version = $3
+		FOR UPDATE`
+
+	var old SavedSearch
+
+	err = tx.QueryRowContext(ctx, query, search.ID, search.UserID, search.Version).Scan(&old.Name, &old.Query, &old.Subscribed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE saved_searches
+		SET name = $1, query = $2, subscribed = $3,
+			last_album_id = CASE WHEN $3 AND NOT subscribed THEN ` + latestAlbumID + ` ELSE last_album_id END,
+			version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING last_album_id, version`
+
+	args := []interface{}{search.Name, search.Query, search.Subscribed, search.ID, search.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&search.LastAlbumID, &search.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "saved_searches_user_id_name_key"`:
+			return ErrDuplicateSavedSearch
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "saved_search.update", "saved_search", search.ID, savedSearchChanges(&old, search))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m SavedSearchModel) Delete(id, userID int64, actor Actor) error {
+	query := `
+		DELETE FROM saved_searches
+		WHERE id = $1 AND user_id = $2
+		RETURNING name, query, subscribed`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old SavedSearch
+
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&old.Name, &old.Query, &old.Subscribed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, "saved_search.delete", "saved_search", id, savedSearchChanges(&old, &SavedSearch{}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkNotified records that a digest covered every album up to albumID.
+func (m SavedSearchModel) MarkNotified(id, albumID int64) error {
+	query := `
+		UPDATE saved_searches
+		SET last_album_id = $1
+		WHERE id = $2 AND last_album_id < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, albumID, id)
+	return err
+}
+
+func savedSearchChanges(old, new *SavedSearch) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.Name != new.Name {
+		changes["name"] = FieldChange{Old: nilIfEmpty(old.Name), New: nilIfEmpty(new.Name)}
+	}
+	if old.Query != new.Query {
+		changes["query"] = FieldChange{Old: nilIfEmpty(old.Query), New: nilIfEmpty(new.Query)}
+	}
+	if old.Subscribed != new.Subscribed {
+		changes["subscribed"] = FieldChange{Old: old.Subscribed, New: new.Subscribed}
+	}
+
+	return changes
+}
+
+func scanSavedSearch(row scanner, extra ...interface{}) (*SavedSearch, error) {
+	var search SavedSearch
+
+	dest := append([]interface{}{
+		&search.ID,
+		&search.UserID,
+		&search.CreatedAt,
+		&search.Name,
+		&search.Query,
+		&search.Subscribed,
+		&search.LastAlbumID,
+		&search.Version,
+	}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &search, nil
+}
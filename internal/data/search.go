@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchConfig is the text search configuration the albums.search_vector
+// trigger indexes with; queries must use the same one to match its lexemes.
+// It's set by AlbumModel.ConfigureSearch.
+var searchConfig = "english"
+
+var searchConfigRX = regexp.MustCompile("^[a-z_][a-z0-9_]*$")
+
+type SearchMatch struct {
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// prefixTSQuery turns free text into a to_tsquery expression that requires
+// every word and treats the last one as a prefix, so partially typed queries
+// still match. Anything but letters and digits is dropped, which keeps user
+// input from being parsed as tsquery operators.
+func prefixTSQuery(q string) string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	words[len(words)-1] += ":*"
+	return strings.Join(words, " & ")
+}
+
+func tsQuery(param string) string {
+	return fmt.Sprintf("to_tsquery('%s', %s)", searchConfig, param)
+}
+
+func tsHeadline(column, param string) string {
+	return fmt.Sprintf("ts_headline('%s', %s, %s, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=TRUE')", searchConfig, column, tsQuery(param))
+}
+
+// Suggest returns the stored title or artist closest to the search's text,
+// for a "did you mean" hint when a search turns up little or nothing. It
+// returns an empty string when nothing is similar enough, or when the best
+// match is what was searched for.
+func (a AlbumModel) Suggest(search AlbumSearch) (string, error) {
+	var text string
+	var columns []string
+
+	switch {
+	case search.Query != "":
+		text, columns = search.Query, []string{"title", "artist"}
+	case search.Artist != "":
+		text, columns = search.Artist, []string{"artist"}
+	case search.Title != "":
+		text, columns = search.Title, []string{"title"}
+	default:
+		return "", nil
+	}
+
+	var selects []string
+	for _, column := range columns {
+		selects = append(selects, fmt.Sprintf(`
+			SELECT %[1]s AS value, similarity(lower(%[1]s), $1) AS score
+			FROM albums
+			WHERE deleted_at IS NULL AND lower(%[1]s) %% $1`, column))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT value
+		FROM (%s) AS candidates
+		ORDER BY score DESC, value ASC
+		LIMIT 1`, strings.Join(selects, " UNION ALL "))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var suggestion string
+
+	err := a.DB.QueryRowContext(ctx, query, strings.ToLower(text)).Scan(&suggestion)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", nil
+		default:
+			return "", err
+		}
+	}
+
+	if strings.EqualFold(suggestion, text) {
+		return "", nil
+	}
+
+	return suggestion, nil
+}
+
+// ConfigureSearch makes config, such as "english" or "simple", the text
+// search configuration used to index and query albums. If it differs from
+// the one albums were indexed with, every album is reindexed, which can take
+// a while on a large catalog.
+func (a AlbumModel) ConfigureSearch(config string) error {
+	if !searchConfigRX.MatchString(config) {
+		return fmt.Errorf("invalid text search configuration %q", config)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+
+	err = tx.QueryRowContext(ctx, "SELECT config::text FROM search_settings FOR UPDATE").Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	if current != config {
+		_, err = tx.ExecContext(ctx, "UPDATE search_settings SET config = $1::regconfig", config)
+		if err != nil {
+			return fmt.Errorf("text search configuration %q: %w", config, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE albums SET title = title")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	searchConfig = config
+	return nil
+}
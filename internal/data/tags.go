@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/validator"
+	"github.com/lib/pq"
+)
+
+var TagRX = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_-]*$`)
+
+// NormalizeTags lowercases and trims each tag, dropping empty ones.
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !validator.PermittedValue(tag, normalized...) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) > 0, "tags", "must contain at least 1 tag")
+	v.Check(len(tags) <= 20, "tags", "must not contain more than 20 tags")
+
+	for _, tag := range tags {
+		v.Check(len(tag) <= 50, "tags", "must not be more than 50 bytes long")
+		v.Check(validator.Matches(tag, TagRX), "tags", "must contain only letters, digits, hyphens and underscores")
+	}
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// AddForAlbum tags an album on behalf of a user and returns all of the user's
+// tags for it.
+func (m TagModel) AddForAlbum(userID, albumID int64, tags []string, actor Actor) ([]string, error) {
+	query := `
+		INSERT INTO album_tags (user_id, album_id, tag)
+		SELECT $1, $2, unnest($3::text[])
+		ON CONFLICT DO NOTHING
+		RETURNING tag`
+
+	return m.change(userID, albumID, "album.tag", query, tags, actor)
+}
+
+// RemoveFromAlbum removes a user's tags from an album and returns the user's
+// remaining tags for it.
+func (m TagModel) RemoveFromAlbum(userID, albumID int64, tags []string, actor Actor) ([]string, error) {
+	query := `
+		DELETE FROM album_tags
+		WHERE user_id = $1 AND album_id = $2 AND tag = ANY($3)
+		RETURNING tag`
+
+	return m.change(userID, albumID, "album.untag", query, tags, actor)
+}
+
+func (m TagModel) change(userID, albumID int64, action, query string, tags []string, actor Actor) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM albums WHERE id = $1 AND deleted_at IS NULL)`, albumID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	changed, err := queryStrings(ctx, tx, query, userID, albumID, pq.Array(tags))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changed) > 0 {
+		change := FieldChange{Old: nil, New: changed}
+		if action == "album.untag" {
+			change = FieldChange{Old: changed, New: nil}
+		}
+
+		err = writeAudit(ctx, tx, actor, action, "album", albumID, map[string]FieldChange{"tags": change})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := queryStrings(ctx, tx, `SELECT tag FROM album_tags WHERE user_id = $1 AND album_id = $2 ORDER BY tag`, userID, albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	return current, tx.Commit()
+}
+
+// GetCloud returns how many of the user's albums carry each of their tags,
+// most used first.
+func (m TagModel) GetCloud(userID int64, limit int) ([]FacetCount, error) {
+	query := `
+		SELECT t.tag, count(*)
+		FROM album_tags t
+		INNER JOIN albums a ON a.id = t.album_id
+		WHERE t.user_id = $1 AND a.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY count(*) DESC, t.tag ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+
+	for rows.Next() {
+		var count FacetCount
+		err := rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+
+	for rows.Next() {
+		var value string
+		err := rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
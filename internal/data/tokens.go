@@ -31,19 +31,28 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Scope:  scope,
 	}
 
+	var err error
+	token.Plaintext, token.Hash, err = generateTokenPlaintext()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func generateTokenPlaintext() (string, []byte, error) {
 	randomBytes := make([]byte, 16)
 
 	_, err := rand.Read(randomBytes)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	hash := sha256.Sum256([]byte(plaintext))
 
-	return token, nil
+	return plaintext, hash[:], nil
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
@@ -55,17 +64,17 @@ type TokenModel struct {
 	DB *sql.DB
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(userID int64, ttl time.Duration, scope string, actor Actor) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(token, actor)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(token *Token, actor Actor) error {
 	query := `
 		INSERT INTO tokens (hash, user_id, expiry, scope) 
 		VALUES ($1, $2, $3, $4)`
@@ -75,11 +84,29 @@ func (m TokenModel) Insert(token *Token) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, "token.create", "user", token.UserID, map[string]FieldChange{
+		"scope":  {Old: nil, New: token.Scope},
+		"expiry": {Old: nil, New: token.Expiry.UTC()},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(scope string, userID int64, actor Actor) error {
 	query := `
 		DELETE FROM tokens 
 		WHERE scope = $1 AND user_id = $2`
@@ -87,6 +114,31 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, scope, userID)
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, scope, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected > 0 {
+		err = writeAudit(ctx, tx, actor, "token.delete", "user", userID, map[string]FieldChange{
+			"scope": {Old: scope, New: nil},
+			"count": {Old: rowsAffected, New: 0},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
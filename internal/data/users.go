@@ -1,14 +1,15 @@
 package data
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/davemolk/recordAPI/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrDuplicateEmail = errors.New("duplicate email")
@@ -31,7 +32,7 @@ type password struct {
 }
 
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := passwordHashers.current.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -42,16 +43,16 @@ func (p *password) Set(plaintextPassword string) error {
 }
 
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
-	return true, nil
+	return hasher.Matches(plaintextPassword, p.hash)
+}
+
+func (p *password) NeedsRehash() bool {
+	current := passwordHashers.current
+	return !current.Identifies(p.hash) || current.NeedsRehash(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -62,7 +63,8 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "no longer than 72 bytes")
+	maxLength := passwordHashers.current.MaxPasswordLength()
+	v.Check(len(password) <= maxLength, "password", fmt.Sprintf("no longer than %d bytes", maxLength))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -84,7 +86,58 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-func (u UserModel) Insert(user *User) error {
+func (u UserModel) Insert(user *User, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Register creates a user with the given permissions in one transaction. If
+// they were invited, the invitation is used up too, and ErrRecordNotFound is
+// returned if it already has been.
+func (u UserModel) Register(user *User, permissions []string, invitation *Invitation, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if invitation != nil {
+		err = deleteInvitation(ctx, tx, invitation, actor)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertUser(ctx, tx, user, actor)
+	if err != nil {
+		return err
+	}
+
+	err = addUserPermissions(ctx, tx, user.ID, actor, permissions...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertUser(ctx context.Context, tx *sql.Tx, user *User, actor Actor) error {
 	query := `
 	INSERT INTO users (name, email, password_hash, activated) 
 	VALUES ($1, $2, $3, $4)
@@ -97,10 +150,7 @@ func (u UserModel) Insert(user *User) error {
 		user.Activated,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -109,7 +159,8 @@ func (u UserModel) Insert(user *User) error {
 			return err
 		}
 	}
-	return nil
+
+	return writeAudit(ctx, tx, actor, "user.register", "user", user.ID, userChanges(&User{}, user))
 }
 
 func (u UserModel) GetByEmail(email string) (*User, error) {
@@ -145,8 +196,35 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (u UserModel) Update(user *User) error {
-	query := ` 
+func (u UserModel) Update(user *User, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT name, email, password_hash, activated
+		FROM users
+		WHERE id = $1 AND version = $2
+		FOR UPDATE`
+
+	var old User
+
+	err = tx.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&old.Name, &old.Email, &old.Password.hash, &old.Activated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = ` 
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1 
 		WHERE id = $5 AND version = $6
@@ -161,10 +239,7 @@ func (u UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -176,7 +251,36 @@ func (u UserModel) Update(user *User) error {
 		}
 	}
 
-	return nil
+	action := "user.update"
+	if !old.Activated && user.Activated {
+		action = "user.activate"
+	}
+
+	err = writeAudit(ctx, tx, actor, action, "user", user.ID, userChanges(&old, user))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func userChanges(old, new *User) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if old.Name != new.Name {
+		changes["name"] = FieldChange{Old: nilIfEmpty(old.Name), New: new.Name}
+	}
+	if old.Email != new.Email {
+		changes["email"] = FieldChange{Old: nilIfEmpty(old.Email), New: new.Email}
+	}
+	if old.Activated != new.Activated {
+		changes["activated"] = FieldChange{Old: old.Activated, New: new.Activated}
+	}
+	if !bytes.Equal(old.Password.hash, new.Password.hash) {
+		changes["password"] = FieldChange{Old: nil, New: "changed"}
+	}
+
+	return changes
 }
 
 func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Valuation is the estimated value of a user's collection. Copies with no
+// usable prices are counted but not valued.
+type Valuation struct {
+	Currency    string            `json:"currency"`
+	Total       float64           `json:"total"`
+	Copies      int               `json:"copies"`
+	Priced      int               `json:"priced"`
+	ByCondition []*ConditionValue `json:"by_condition"`
+	Items       []*CopyValue      `json:"items"`
+}
+
+// ConditionValue totals the copies in one condition. An empty Condition
+// covers copies with no recorded condition.
+type ConditionValue struct {
+	Condition string  `json:"condition"`
+	Copies    int     `json:"copies"`
+	Priced    int     `json:"priced"`
+	Value     float64 `json:"value"`
+}
+
+// CopyValue is one copy's estimated value, the median of the prices it was
+// based on. Value is nil if no prices could be used.
+type CopyValue struct {
+	CopyID         int64      `json:"copy_id"`
+	Album          *Album     `json:"album"`
+	Condition      string     `json:"condition,omitempty"`
+	Value          *float64   `json:"value"`
+	Prices         int        `json:"prices"`
+	LastObservedAt *time.Time `json:"last_observed_at,omitempty"`
+}
+
+// Valuate estimates the value of each copy in currency from its prices,
+// skipping prices in currencies with no exchange rate.
+func Valuate(collection []*CopyPrices, rates Rates, currency string) *Valuation {
+	valuation := &Valuation{
+		Currency:    currency,
+		ByCondition: []*ConditionValue{},
+		Items:       []*CopyValue{},
+	}
+
+	byCondition := map[string]*ConditionValue{}
+
+	for _, entry := range collection {
+		item := &CopyValue{
+			CopyID:    entry.Copy.ID,
+			Album:     entry.Copy.Album,
+			Condition: entry.Copy.Condition,
+		}
+
+		var amounts []float64
+
+		for _, price := range entry.Prices {
+			amount, ok := rates.Convert(price.Amount, price.Currency, currency)
+			if !ok {
+				continue
+			}
+
+			amounts = append(amounts, amount)
+			if item.LastObservedAt == nil {
+				observedAt := price.ObservedAt
+				item.LastObservedAt = &observedAt
+			}
+		}
+
+		condition, ok := byCondition[item.Condition]
+		if !ok {
+			condition = &ConditionValue{Condition: item.Condition}
+			byCondition[item.Condition] = condition
+		}
+
+		condition.Copies++
+		valuation.Copies++
+
+		if len(amounts) > 0 {
+			value := roundAmount(median(amounts))
+			item.Value = &value
+			item.Prices = len(amounts)
+
+			condition.Priced++
+			condition.Value += value
+			valuation.Priced++
+			valuation.Total += value
+		}
+
+		valuation.Items = append(valuation.Items, item)
+	}
+
+	// List conditions best first, then copies with no recorded condition.
+	grades := append([]string{}, CopyConditions...)
+	grades = append(grades, "")
+
+	for _, grade := range grades {
+		if condition, ok := byCondition[grade]; ok {
+			condition.Value = roundAmount(condition.Value)
+			valuation.ByCondition = append(valuation.ByCondition, condition)
+		}
+	}
+
+	valuation.Total = roundAmount(valuation.Total)
+
+	return valuation
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
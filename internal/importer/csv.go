@@ -0,0 +1,171 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+var Fields = []string{"title", "artist", "genres", "year"}
+
+// Mapping maps album fields to the names of the columns holding them. Fields
+// that aren't mapped are read from the column with the same name, if any.
+type Mapping map[string]string
+
+// ParseMapping parses a mapping spec such as "title=Album,artist=Band".
+func ParseMapping(spec string) (Mapping, error) {
+	mapping := Mapping{}
+
+	if strings.TrimSpace(spec) == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		field, column, found := strings.Cut(pair, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		column = strings.TrimSpace(column)
+
+		switch {
+		case !found || column == "":
+			return nil, fmt.Errorf("%q must be in the form field=column", pair)
+		case !validator.PermittedValue(field, Fields...):
+			return nil, fmt.Errorf("unknown field %q", field)
+		case mapping[field] != "":
+			return nil, fmt.Errorf("field %q is mapped more than once", field)
+		}
+
+		mapping[field] = column
+	}
+
+	return mapping, nil
+}
+
+// Row is one album read from an import file. Line is the line it started on,
+// and Errors holds any values that couldn't be parsed.
+type Row struct {
+	Line   int
+	Album  *data.Album
+	Errors map[string]string
+}
+
+type CSVOptions struct {
+	Comma          rune
+	Mapping        Mapping
+	GenreSeparator string
+}
+
+// ReadCSV reads albums from CSV (or, with a tab as Comma, TSV) data whose
+// first row holds the column names.
+func ReadCSV(r io.Reader, opts CSVOptions) ([]*Row, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = opts.Comma
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("file is empty")
+		}
+		return nil, err
+	}
+
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if _, ok := columns[key]; !ok {
+			columns[key] = i
+		}
+	}
+
+	index := map[string]int{}
+	for _, field := range Fields {
+		name, mapped := opts.Mapping[field]
+		if !mapped {
+			name = field
+		}
+
+		i, ok := columns[strings.ToLower(name)]
+		switch {
+		case ok:
+			index[field] = i
+		case mapped:
+			return nil, fmt.Errorf("column %q mapped to %s is not in the header", name, field)
+		case field == "title" || field == "artist":
+			return nil, fmt.Errorf("no column for %s; add one to the header or map it with the mapping parameter", field)
+		}
+	}
+
+	rows := []*Row{}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if blankRecord(record) {
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, readRow(record, line, index, opts.GenreSeparator))
+	}
+
+	return rows, nil
+}
+
+func readRow(record []string, line int, index map[string]int, genreSeparator string) *Row {
+	row := &Row{Line: line, Album: &data.Album{Genres: []string{}}, Errors: map[string]string{}}
+
+	value := func(field string) string {
+		i, ok := index[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row.Album.Title = value("title")
+	row.Album.Artist = value("artist")
+
+	if genres := value("genres"); genres != "" {
+		for _, genre := range strings.Split(genres, genreSeparator) {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				row.Album.Genres = append(row.Album.Genres, genre)
+			}
+		}
+	}
+
+	if year := value("year"); year != "" {
+		n, err := strconv.ParseInt(year, 10, 32)
+		if err != nil {
+			row.Errors["year"] = "must be an integer value"
+		}
+		row.Album.Year = int32(n)
+	}
+
+	return row
+}
+
+func blankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
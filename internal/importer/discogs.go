@@ -0,0 +1,466 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+// DiscogsSource is the album source name used to link albums to Discogs
+// releases.
+const DiscogsSource = "discogs"
+
+// discogsArtistSuffix matches the " (2)" Discogs appends to tell apart
+// artists and labels that share a name.
+var discogsArtistSuffix = regexp.MustCompile(`\s+\(\d+\)$`)
+
+type DiscogsArtist struct {
+	Name string `json:"name"`
+	Join string `json:"join"`
+}
+
+type DiscogsLabel struct {
+	Name  string `json:"name"`
+	CatNo string `json:"catno"`
+}
+
+type DiscogsIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type DiscogsFormat struct {
+	Name         string   `json:"name"`
+	Descriptions []string `json:"descriptions"`
+}
+
+// DiscogsRelease holds the parts of a Discogs release the importer uses. Line
+// is set for releases read from a collection CSV export.
+type DiscogsRelease struct {
+	ID          int64               `json:"id"`
+	Title       string              `json:"title"`
+	Year        int32               `json:"year"`
+	Artists     []DiscogsArtist     `json:"artists"`
+	Genres      []string            `json:"genres"`
+	Styles      []string            `json:"styles"`
+	Labels      []DiscogsLabel      `json:"labels"`
+	Formats     []DiscogsFormat     `json:"formats"`
+	Identifiers []DiscogsIdentifier `json:"identifiers"`
+	Line        int                 `json:"-"`
+
+	// Collection and wantlist items from the Discogs API wrap the release.
+	BasicInformation *DiscogsRelease `json:"basic_information"`
+}
+
+// ReadDiscogsCSV reads the CSV file Discogs produces when exporting a
+// collection.
+func ReadDiscogsCSV(r io.Reader) ([]*DiscogsRelease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("file is empty")
+		}
+		return nil, err
+	}
+
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"release_id", "artist", "title"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("file has no %q column", name)
+		}
+	}
+
+	var releases []*DiscogsRelease
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if blankRecord(record) {
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		release := &DiscogsRelease{
+			Title:   value("title"),
+			Artists: []DiscogsArtist{{Name: value("artist")}},
+			Line:    line,
+		}
+
+		// The export has no release ID of 0, so a blank or malformed one is
+		// left as 0 and reported when the release is imported.
+		release.ID, _ = strconv.ParseInt(value("release_id"), 10, 64)
+
+		if released := value("released"); len(released) >= 4 {
+			year, err := strconv.ParseInt(released[:4], 10, 32)
+			if err == nil {
+				release.Year = int32(year)
+			}
+		}
+
+		if label := value("label"); label != "" {
+			release.Labels = []DiscogsLabel{{Name: label, CatNo: value("catalog#")}}
+		}
+
+		if format := value("format"); format != "" {
+			release.Formats = []DiscogsFormat{{Name: format}}
+		}
+
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
+
+// ReadDiscogsJSON reads releases from a Discogs JSON dump: a single release,
+// an array of releases, or an object with a "releases" array such as a page
+// of the collection API. Collection items wrapped in "basic_information" are
+// unwrapped.
+func ReadDiscogsJSON(r io.Reader) ([]*DiscogsRelease, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	body = bytes.TrimSpace(body)
+
+	var releases []*DiscogsRelease
+
+	switch {
+	case len(body) == 0:
+		return nil, errors.New("file is empty")
+	case body[0] == '[':
+		err = json.Unmarshal(body, &releases)
+	case body[0] == '{':
+		var dump struct {
+			Releases []*DiscogsRelease `json:"releases"`
+		}
+
+		err = json.Unmarshal(body, &dump)
+		if err != nil {
+			break
+		}
+
+		if dump.Releases != nil {
+			releases = dump.Releases
+			break
+		}
+
+		var release DiscogsRelease
+		err = json.Unmarshal(body, &release)
+		releases = []*DiscogsRelease{&release}
+	default:
+		return nil, errors.New("file must contain a JSON object or array")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("file contains badly-formed JSON: %w", err)
+	}
+
+	for i, release := range releases {
+		if release == nil {
+			return nil, fmt.Errorf("release %d is null", i+1)
+		}
+		if release.BasicInformation != nil {
+			unwrapped := release.BasicInformation
+			if unwrapped.ID == 0 {
+				unwrapped.ID = release.ID
+			}
+			releases[i] = unwrapped
+		}
+	}
+
+	return releases, nil
+}
+
+// Artist joins the release's artist credits the way Discogs displays them,
+// such as "Miles Davis & John Coltrane".
+func (r *DiscogsRelease) Artist() string {
+	var sb strings.Builder
+
+	for i, artist := range r.Artists {
+		sb.WriteString(discogsArtistSuffix.ReplaceAllString(strings.TrimSpace(artist.Name), ""))
+
+		if i == len(r.Artists)-1 {
+			break
+		}
+
+		switch join := strings.TrimSpace(artist.Join); join {
+		case "", ",":
+			sb.WriteString(", ")
+		default:
+			sb.WriteString(" " + join + " ")
+		}
+	}
+
+	return sb.String()
+}
+
+// Format describes the release's formats, such as "Vinyl, LP, Album".
+func (r *DiscogsRelease) Format() string {
+	formats := make([]string, 0, len(r.Formats))
+
+	for _, format := range r.Formats {
+		parts := append([]string{format.Name}, format.Descriptions...)
+		formats = append(formats, strings.Join(parts, ", "))
+	}
+
+	return strings.Join(formats, " + ")
+}
+
+// Album maps the release to an album. Discogs genres and styles both become
+// album genres, except those missing from the genre taxonomy, which are
+// returned separately.
+func (r *DiscogsRelease) Album() (*data.Album, []string) {
+	genres, unknown := data.NormalizeGenres(append(append([]string{}, r.Genres...), r.Styles...))
+
+	album := &data.Album{
+		Title:  strings.TrimSpace(r.Title),
+		Artist: r.Artist(),
+		Genres: genres,
+		Year:   r.Year,
+	}
+
+	return album, unknown
+}
+
+// Source links an album to the release, keeping the label and format details
+// albums have no fields for.
+func (r *DiscogsRelease) Source() *data.AlbumSource {
+	metadata := map[string]string{}
+
+	var labels []string
+	for _, label := range r.Labels {
+		name := discogsArtistSuffix.ReplaceAllString(strings.TrimSpace(label.Name), "")
+		if name != "" && !validator.PermittedValue(name, labels...) {
+			labels = append(labels, name)
+		}
+		if metadata["catalog_number"] == "" && label.CatNo != "" {
+			metadata["catalog_number"] = label.CatNo
+		}
+	}
+	if len(labels) > 0 {
+		metadata["label"] = strings.Join(labels, ", ")
+	}
+
+	if format := r.Format(); format != "" {
+		metadata["format"] = format
+	}
+
+	for _, identifier := range r.Identifiers {
+		if strings.EqualFold(identifier.Type, "Barcode") {
+			metadata["barcode"] = strings.ReplaceAll(identifier.Value, " ", "")
+			break
+		}
+	}
+
+	return &data.AlbumSource{
+		Source:     DiscogsSource,
+		ExternalID: strconv.FormatInt(r.ID, 10),
+		Metadata:   metadata,
+	}
+}
+
+type DiscogsResult struct {
+	ReleaseID int64             `json:"release_id"`
+	Line      int               `json:"line,omitempty"`
+	AlbumID   int64             `json:"album_id,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Artist    string            `json:"artist,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// DiscogsReport describes the outcome of a Discogs import. Matched releases
+// were linked to albums that already existed rather than creating new ones.
+// For a dry run nothing is written, and albums that would have been created
+// have no ID.
+type DiscogsReport struct {
+	DryRun        bool            `json:"dry_run"`
+	Releases      int             `json:"releases"`
+	Created       []DiscogsResult `json:"created"`
+	Matched       []DiscogsResult `json:"matched"`
+	Skipped       []DiscogsResult `json:"skipped"`
+	UnknownGenres []string        `json:"unknown_genres"`
+}
+
+// ImportDiscogs creates an album for each release, linked to the release so
+// importing it again matches instead. A release that isn't linked yet but has
+// the same title and artist as an existing album (or an earlier release in
+// the import) is linked to that album, unless opts.AllowDuplicates is set.
+// If a write fails the import stops, and the returned report covers the
+// releases handled so far.
+func ImportDiscogs(models data.Models, releases []*DiscogsRelease, actor data.Actor, opts Options) (*DiscogsReport, error) {
+	report := &DiscogsReport{
+		DryRun:        opts.DryRun,
+		Releases:      len(releases),
+		Created:       []DiscogsResult{},
+		Matched:       []DiscogsResult{},
+		Skipped:       []DiscogsResult{},
+		UnknownGenres: []string{},
+	}
+
+	type candidate struct {
+		release *DiscogsRelease
+		album   *data.Album
+		source  *data.AlbumSource
+		result  DiscogsResult
+	}
+
+	var candidates []*candidate
+	var externalIDs []string
+	seenIDs := map[int64]bool{}
+	unknownGenres := map[string]bool{}
+
+	for _, release := range releases {
+		album, unknown := release.Album()
+		for _, genre := range unknown {
+			unknownGenres[genre] = true
+		}
+
+		result := DiscogsResult{ReleaseID: release.ID, Line: release.Line, Title: album.Title, Artist: album.Artist}
+
+		v := validator.New()
+		v.Check(release.ID > 0, "release_id", "must be a positive integer")
+
+		if data.ValidateAlbum(v, album); !v.Valid() {
+			result.Reason = "invalid"
+			result.Errors = v.Errors
+			report.Skipped = append(report.Skipped, result)
+			continue
+		}
+
+		if seenIDs[release.ID] {
+			result.Reason = "release appears earlier in the file"
+			report.Skipped = append(report.Skipped, result)
+			continue
+		}
+		seenIDs[release.ID] = true
+
+		c := &candidate{release: release, album: album, source: release.Source(), result: result}
+		candidates = append(candidates, c)
+		externalIDs = append(externalIDs, c.source.ExternalID)
+	}
+
+	for genre := range unknownGenres {
+		report.UnknownGenres = append(report.UnknownGenres, genre)
+	}
+	sort.Strings(report.UnknownGenres)
+
+	if len(candidates) == 0 {
+		return report, nil
+	}
+
+	linked, err := models.AlbumSources.FindAlbums(DiscogsSource, externalIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlinked []*candidate
+	var albums []*data.Album
+
+	for _, c := range candidates {
+		if id, ok := linked[c.source.ExternalID]; ok {
+			c.result.AlbumID = id
+			c.result.Reason = "already imported"
+			report.Matched = append(report.Matched, c.result)
+			continue
+		}
+		unlinked = append(unlinked, c)
+		albums = append(albums, c.album)
+	}
+
+	existing := map[int]int64{}
+	if len(albums) > 0 && !opts.AllowDuplicates {
+		existing, err = models.Albums.FindExisting(albums)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// created maps the title and artist of each album created by this import
+	// to its ID, so later pressings of the same album are linked to it.
+	created := map[string]int64{}
+
+	for i, c := range unlinked {
+		key := strings.ToLower(c.album.Title) + "\x00" + strings.ToLower(c.album.Artist)
+
+		albumID, matched := existing[i]
+		if !matched && !opts.AllowDuplicates {
+			albumID, matched = created[key]
+		}
+
+		switch {
+		case matched:
+			c.source.AlbumID = albumID
+			c.result.AlbumID = albumID
+			c.result.Reason = "same title and artist"
+
+			if !opts.DryRun && albumID != 0 {
+				err = models.AlbumSources.Link(c.source, actor)
+				if err != nil {
+					return report, err
+				}
+			}
+
+			report.Matched = append(report.Matched, c.result)
+		case opts.DryRun:
+			created[key] = 0
+			report.Created = append(report.Created, c.result)
+		default:
+			err = models.AlbumSources.InsertAlbum(c.album, c.source, actor)
+			if err != nil {
+				return report, err
+			}
+
+			created[key] = c.album.ID
+			c.result.AlbumID = c.album.ID
+			report.Created = append(report.Created, c.result)
+		}
+
+		if opts.Progress != nil && (i+1)%100 == 0 {
+			opts.Progress(i+1, len(unlinked))
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(len(unlinked), len(unlinked))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,130 @@
+// Package importer loads albums in bulk from files exported by spreadsheets
+// and other collection tools.
+package importer
+
+import (
+	"strings"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+type Options struct {
+	// DryRun validates the rows and reports what would be imported without
+	// writing anything.
+	DryRun bool
+	// AllowDuplicates imports rows that look like an existing album or an
+	// earlier row, rather than skipping them.
+	AllowDuplicates bool
+	// Progress, if set, is called periodically with the number of rows
+	// handled so far.
+	Progress func(processed, total int)
+}
+
+type RowErrors struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+type Duplicate struct {
+	Line            int   `json:"line"`
+	AlbumID         int64 `json:"album_id,omitempty"`
+	DuplicateOfLine int   `json:"duplicate_of_line,omitempty"`
+}
+
+// Report describes the outcome of an import. For a dry run, Imported is the
+// number of rows that would have been imported.
+type Report struct {
+	DryRun     bool        `json:"dry_run"`
+	Rows       int         `json:"rows"`
+	Imported   int         `json:"imported"`
+	Invalid    []RowErrors `json:"invalid"`
+	Duplicates []Duplicate `json:"duplicates"`
+}
+
+// Import validates each row with data.ValidateAlbum and inserts the valid ones
+// through AlbumModel.Insert, skipping probable duplicates of existing albums
+// or earlier rows unless opts.AllowDuplicates is set. If an insert fails the
+// import stops, and the returned report covers the rows imported so far.
+func Import(albums data.AlbumModel, rows []*Row, actor data.Actor, opts Options) (*Report, error) {
+	report := &Report{
+		DryRun:     opts.DryRun,
+		Rows:       len(rows),
+		Invalid:    []RowErrors{},
+		Duplicates: []Duplicate{},
+	}
+
+	var valid []*Row
+	var candidates []*data.Album
+
+	for _, row := range rows {
+		v := validator.New()
+		for key, message := range row.Errors {
+			v.AddError(key, message)
+		}
+
+		if data.ValidateAlbum(v, row.Album); !v.Valid() {
+			report.Invalid = append(report.Invalid, RowErrors{Line: row.Line, Errors: v.Errors})
+			continue
+		}
+
+		valid = append(valid, row)
+		candidates = append(candidates, row.Album)
+	}
+
+	existing := map[int]int64{}
+	if len(candidates) > 0 {
+		var err error
+		existing, err = albums.FindExisting(candidates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[string]int{}
+	var pending []*Row
+
+	for i, row := range valid {
+		key := strings.ToLower(row.Album.Title) + "\x00" + strings.ToLower(row.Album.Artist)
+
+		duplicate := Duplicate{Line: row.Line, AlbumID: existing[i]}
+		if duplicate.AlbumID == 0 {
+			duplicate.DuplicateOfLine = seen[key]
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = row.Line
+		}
+
+		if duplicate.AlbumID != 0 || duplicate.DuplicateOfLine != 0 {
+			report.Duplicates = append(report.Duplicates, duplicate)
+			if !opts.AllowDuplicates {
+				continue
+			}
+		}
+
+		pending = append(pending, row)
+	}
+
+	if opts.DryRun {
+		report.Imported = len(pending)
+		return report, nil
+	}
+
+	for i, row := range pending {
+		err := albums.Insert(row.Album, actor)
+		if err != nil {
+			return report, err
+		}
+		report.Imported++
+
+		if opts.Progress != nil && (i+1)%100 == 0 {
+			opts.Progress(i+1, len(pending))
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(len(pending), len(pending))
+	}
+
+	return report, nil
+}
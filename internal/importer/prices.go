@@ -0,0 +1,189 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"github.com/davemolk/recordAPI/internal/validator"
+)
+
+var PriceFields = []string{"album_id", "source", "condition", "currency", "amount", "observed_at"}
+
+// PriceRow is one price read from an import file, like Row.
+type PriceRow struct {
+	Line   int
+	Price  *data.Price
+	Errors map[string]string
+}
+
+// PriceReport describes the outcome of a price import. For a dry run,
+// Imported is the number of rows that would have been imported.
+type PriceReport struct {
+	DryRun   bool        `json:"dry_run"`
+	Rows     int         `json:"rows"`
+	Imported int         `json:"imported"`
+	Invalid  []RowErrors `json:"invalid"`
+}
+
+// ReadPriceCSV reads prices from CSV data whose first row holds the column
+// names in PriceFields. Rows without a source use defaultSource, and
+// observed_at may be a date or an RFC 3339 timestamp.
+func ReadPriceCSV(r io.Reader, defaultSource string) ([]*PriceRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("file is empty")
+		}
+		return nil, err
+	}
+
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if _, ok := index[key]; !ok && validator.PermittedValue(key, PriceFields...) {
+			index[key] = i
+		}
+	}
+
+	for _, field := range []string{"album_id", "currency", "amount", "observed_at"} {
+		if _, ok := index[field]; !ok {
+			return nil, fmt.Errorf("no column for %s; add one to the header", field)
+		}
+	}
+
+	rows := []*PriceRow{}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if blankRecord(record) {
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, readPriceRow(record, line, index, defaultSource))
+	}
+
+	return rows, nil
+}
+
+func readPriceRow(record []string, line int, index map[string]int, defaultSource string) *PriceRow {
+	row := &PriceRow{Line: line, Price: &data.Price{}, Errors: map[string]string{}}
+
+	value := func(field string) string {
+		i, ok := index[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	if id := value("album_id"); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			row.Errors["album_id"] = "must be an integer value"
+		}
+		row.Price.AlbumID = n
+	}
+
+	row.Price.Source = value("source")
+	if row.Price.Source == "" {
+		row.Price.Source = defaultSource
+	}
+
+	row.Price.Condition = strings.ToUpper(value("condition"))
+	row.Price.Currency = strings.ToUpper(value("currency"))
+
+	if amount := value("amount"); amount != "" {
+		n, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			row.Errors["amount"] = "must be a number"
+		}
+		row.Price.Amount = n
+	}
+
+	if observedAt := value("observed_at"); observedAt != "" {
+		t, err := time.Parse("2006-01-02", observedAt)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, observedAt)
+		}
+		if err != nil {
+			row.Errors["observed_at"] = "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp"
+		}
+		row.Price.ObservedAt = t
+	}
+
+	return row
+}
+
+// ImportPrices validates each row with data.ValidatePrice and records the
+// valid ones in a single batch. Nothing is recorded for a dry run.
+func ImportPrices(models data.Models, rows []*PriceRow, actor data.Actor, dryRun bool) (*PriceReport, error) {
+	report := &PriceReport{
+		DryRun:  dryRun,
+		Rows:    len(rows),
+		Invalid: []RowErrors{},
+	}
+
+	albumIDs := make([]int64, len(rows))
+	for i, row := range rows {
+		albumIDs[i] = row.Price.AlbumID
+	}
+
+	missing, err := models.Albums.Missing(albumIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var valid []*data.Price
+
+	for _, row := range rows {
+		v := validator.New()
+
+		for field, message := range row.Errors {
+			v.AddError(field, message)
+		}
+
+		data.ValidatePrice(v, row.Price)
+		v.Check(row.Price.AlbumID <= 0 || !missing[row.Price.AlbumID], "album_id", "must be an existing album")
+
+		if !v.Valid() {
+			report.Invalid = append(report.Invalid, RowErrors{Line: row.Line, Errors: v.Errors})
+			continue
+		}
+
+		valid = append(valid, row.Price)
+	}
+
+	if dryRun {
+		report.Imported = len(valid)
+		return report, nil
+	}
+
+	report.Imported, err = models.Prices.InsertMany(valid, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,88 @@
+// Package metadata looks up album details, such as track lists, release dates
+// and canonical artist names, in external catalogues.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/davemolk/recordAPI/internal/data"
+)
+
+var ErrNoMatch = errors.New("no matching release")
+
+// Provider looks up releases in an external catalogue. Name is used as the
+// source of the album_sources rows linking albums to the catalogue.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, query Query) (*Release, error)
+}
+
+// Query identifies the release to look up: by ReleaseID if it's set, and
+// otherwise by searching for the title and artist.
+type Query struct {
+	ReleaseID string
+	Title     string
+	Artist    string
+	Year      int32
+}
+
+type Release struct {
+	ID             string
+	Title          string
+	Artist         string
+	ArtistIDs      []string
+	ReleaseGroupID string
+	Date           string
+	Barcode        string
+	Year           int32
+	Tracks         []data.Track
+}
+
+// Apply fills in the album from the release, replacing the artist with its
+// canonical name and setting the year if the album doesn't have one. It
+// reports whether the album changed.
+func (r *Release) Apply(album *data.Album) bool {
+	changed := false
+
+	if r.Artist != "" && r.Artist != album.Artist {
+		album.Artist = r.Artist
+		changed = true
+	}
+
+	if album.Year == 0 && r.Year != 0 {
+		album.Year = r.Year
+		changed = true
+	}
+
+	return changed
+}
+
+// Source links an album to the release in the named provider's catalogue.
+func (r *Release) Source(provider string) *data.AlbumSource {
+	metadata := map[string]string{}
+
+	if r.Date != "" {
+		metadata["date"] = r.Date
+	}
+	if r.Barcode != "" {
+		metadata["barcode"] = r.Barcode
+	}
+	if r.ReleaseGroupID != "" {
+		metadata["release_group_id"] = r.ReleaseGroupID
+	}
+	for i, id := range r.ArtistIDs {
+		key := "artist_id"
+		if i > 0 {
+			key += "_" + strconv.Itoa(i+1)
+		}
+		metadata[key] = id
+	}
+
+	return &data.AlbumSource{
+		Source:     provider,
+		ExternalID: r.ID,
+		Metadata:   metadata,
+	}
+}
@@ -0,0 +1,229 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davemolk/recordAPI/internal/data"
+	"golang.org/x/time/rate"
+)
+
+const MusicBrainzURL = "https://musicbrainz.org/ws/2"
+
+// musicBrainzAttempts is how many times a request is tried when MusicBrainz
+// answers 503, which it does when clients exceed the rate limit.
+const musicBrainzAttempts = 3
+
+var musicBrainzQueryEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// MusicBrainz looks up releases with the MusicBrainz web service. Requests
+// are limited to one a second, as MusicBrainz asks, and must identify the
+// application in UserAgent.
+type MusicBrainz struct {
+	BaseURL   string
+	UserAgent string
+	Client    *http.Client
+	// MinScore is the lowest search score (0-100) accepted as a match.
+	MinScore int
+
+	limiter *rate.Limiter
+}
+
+func NewMusicBrainz(baseURL, userAgent string) *MusicBrainz {
+	return &MusicBrainz{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		UserAgent: userAgent,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		MinScore:  90,
+		limiter:   rate.NewLimiter(rate.Every(time.Second), 1),
+	}
+}
+
+func (mb *MusicBrainz) Name() string {
+	return "musicbrainz"
+}
+
+type mbRelease struct {
+	ID           string `json:"id"`
+	Score        int    `json:"score"`
+	Title        string `json:"title"`
+	Date         string `json:"date"`
+	Barcode      string `json:"barcode"`
+	ArtistCredit []struct {
+		JoinPhrase string `json:"joinphrase"`
+		Artist     struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"artist"`
+	} `json:"artist-credit"`
+	ReleaseGroup struct {
+		ID string `json:"id"`
+	} `json:"release-group"`
+	Media []struct {
+		Position int `json:"position"`
+		Tracks   []struct {
+			Position int    `json:"position"`
+			Title    string `json:"title"`
+			Length   int    `json:"length"`
+		} `json:"tracks"`
+	} `json:"media"`
+}
+
+func (mb *MusicBrainz) Lookup(ctx context.Context, query Query) (*Release, error) {
+	id := query.ReleaseID
+
+	if id == "" {
+		var err error
+		id, err = mb.search(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var release mbRelease
+
+	params := url.Values{"inc": {"artist-credits recordings release-groups"}}
+
+	err := mb.get(ctx, "/release/"+url.PathEscape(id), params, &release)
+	if err != nil {
+		return nil, err
+	}
+
+	return release.toRelease(), nil
+}
+
+// search returns the ID of the best-scoring release with the query's title
+// and artist, preferring one from the same year.
+func (mb *MusicBrainz) search(ctx context.Context, query Query) (string, error) {
+	var result struct {
+		Releases []mbRelease `json:"releases"`
+	}
+
+	q := fmt.Sprintf(`release:"%s" AND artist:"%s"`, musicBrainzQueryEscaper.Replace(query.Title), musicBrainzQueryEscaper.Replace(query.Artist))
+
+	err := mb.get(ctx, "/release", url.Values{"query": {q}, "limit": {"10"}}, &result)
+	if err != nil {
+		return "", err
+	}
+
+	id := ""
+	for _, release := range result.Releases {
+		if release.Score < mb.MinScore {
+			continue
+		}
+		if query.Year == 0 || releaseYear(release.Date) == query.Year {
+			return release.ID, nil
+		}
+		if id == "" {
+			id = release.ID
+		}
+	}
+
+	if id == "" {
+		return "", ErrNoMatch
+	}
+
+	return id, nil
+}
+
+func (mb *MusicBrainz) get(ctx context.Context, path string, params url.Values, dst interface{}) error {
+	params.Set("fmt", "json")
+	u := mb.BaseURL + path + "?" + params.Encode()
+
+	for attempt := 1; ; attempt++ {
+		err := mb.limiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", mb.UserAgent)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := mb.Client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(dst)
+			resp.Body.Close()
+			return err
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+			return ErrNoMatch
+		case resp.StatusCode == http.StatusServiceUnavailable && attempt < musicBrainzAttempts:
+			wait := time.Duration(attempt) * time.Second
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			return fmt.Errorf("musicbrainz returned %s", resp.Status)
+		}
+	}
+}
+
+func (r *mbRelease) toRelease() *Release {
+	release := &Release{
+		ID:             r.ID,
+		Title:          r.Title,
+		ReleaseGroupID: r.ReleaseGroup.ID,
+		Date:           r.Date,
+		Barcode:        r.Barcode,
+		Year:           releaseYear(r.Date),
+		Tracks:         []data.Track{},
+	}
+
+	var artist strings.Builder
+	for _, credit := range r.ArtistCredit {
+		artist.WriteString(credit.Artist.Name + credit.JoinPhrase)
+		release.ArtistIDs = append(release.ArtistIDs, credit.Artist.ID)
+	}
+	release.Artist = strings.TrimSpace(artist.String())
+
+	for _, medium := range r.Media {
+		for _, track := range medium.Tracks {
+			release.Tracks = append(release.Tracks, data.Track{
+				Disc:     medium.Position,
+				Position: track.Position,
+				Title:    track.Title,
+				LengthMS: track.Length,
+			})
+		}
+	}
+
+	return release
+}
+
+// releaseYear returns the year of a date such as "1959", "1959-08" or
+// "1959-08-17", or 0 if it has none.
+func releaseYear(date string) int32 {
+	if len(date) < 4 {
+		return 0
+	}
+
+	year, err := strconv.ParseInt(date[:4], 10, 32)
+	if err != nil {
+		return 0
+	}
+
+	return int32(year)
+}
@@ -0,0 +1,245 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testUserAgent = "recordAPI-test/1.0 ( test@example.com )"
+
+// recorded serves a MusicBrainz response recorded in testdata.
+func recorded(t *testing.T, name string) http.HandlerFunc {
+	t.Helper()
+
+	js, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
+func newTestMusicBrainz(t *testing.T, handler http.Handler) *MusicBrainz {
+	t.Helper()
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return NewMusicBrainz(ts.URL+"/ws/2/", testUserAgent)
+}
+
+func TestMusicBrainzLookup(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var searchQuery string
+
+	search := recorded(t, "release_search.json")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/2/release", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		searchQuery = r.URL.Query().Get("query")
+		mu.Unlock()
+
+		search(w, r)
+	})
+	mux.Handle("/ws/2/release/0e3d7f6a-2d71-4c6b-9d7d-5b4a1f0e8c21", recorded(t, "release.json"))
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != testUserAgent {
+			t.Errorf("User-Agent = %q; want %q", got, testUserAgent)
+		}
+		if got := r.URL.Query().Get("fmt"); got != "json" {
+			t.Errorf("fmt = %q; want json", got)
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	mb := newTestMusicBrainz(t, handler)
+
+	release, err := mb.Lookup(context.Background(), Query{Title: `Kind of "Blue"`, Artist: "Miles Davis", Year: 1959})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	wantQuery := `release:"Kind of \"Blue\"" AND artist:"Miles Davis"`
+	if searchQuery != wantQuery {
+		t.Errorf("search query = %q; want %q", searchQuery, wantQuery)
+	}
+
+	// The first result scores higher, but the second is from the right year.
+	if release.ID != "0e3d7f6a-2d71-4c6b-9d7d-5b4a1f0e8c21" {
+		t.Errorf("ID = %q; want the 1959 release", release.ID)
+	}
+	if release.Artist != "Miles Davis" {
+		t.Errorf("Artist = %q; want %q", release.Artist, "Miles Davis")
+	}
+	if release.Year != 1959 {
+		t.Errorf("Year = %d; want 1959", release.Year)
+	}
+	if release.ReleaseGroupID != "8e8a594f-2175-3b4b-8e2a-4cc4c7e3c9f5" {
+		t.Errorf("ReleaseGroupID = %q", release.ReleaseGroupID)
+	}
+	if len(release.ArtistIDs) != 1 || release.ArtistIDs[0] != "561d854a-6a28-4aa7-8c99-323e6ce46c2a" {
+		t.Errorf("ArtistIDs = %v", release.ArtistIDs)
+	}
+
+	if len(release.Tracks) != 5 {
+		t.Fatalf("got %d tracks; want 5", len(release.Tracks))
+	}
+
+	last := release.Tracks[4]
+	if last.Disc != 2 || last.Position != 2 || last.Title != "Flamenco Sketches" || last.LengthMS != 566000 {
+		t.Errorf("last track = %+v", last)
+	}
+}
+
+func TestMusicBrainzLookupNoMatch(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws/2/release", recorded(t, "release_search.json"))
+
+	mb := newTestMusicBrainz(t, mux)
+	mb.MinScore = 101
+
+	_, err := mb.Lookup(context.Background(), Query{Title: "Kind of Blue", Artist: "Miles Davis"})
+	if !errors.Is(err, ErrNoMatch) {
+		t.Errorf("err = %v; want ErrNoMatch", err)
+	}
+}
+
+func TestMusicBrainzRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var times []time.Time
+
+	release := recorded(t, "release.json")
+
+	mb := newTestMusicBrainz(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+
+		release(w, r)
+	}))
+
+	for i := 0; i < 3; i++ {
+		_, err := mb.Lookup(context.Background(), Query{ReleaseID: "0e3d7f6a-2d71-4c6b-9d7d-5b4a1f0e8c21"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 950*time.Millisecond {
+			t.Errorf("request %d came %v after the previous one; want at least 1s", i+1, gap)
+		}
+	}
+}
+
+func TestMusicBrainzErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  error
+		wantAny  bool
+		requests int
+	}{
+		{name: "retries after 503", statuses: []int{503, 200}, requests: 2},
+		{name: "gives up after repeated 503s", statuses: []int{503, 503, 503}, wantAny: true, requests: musicBrainzAttempts},
+		{name: "not found", statuses: []int{404}, wantErr: ErrNoMatch, requests: 1},
+		{name: "bad request", statuses: []int{400}, wantErr: ErrNoMatch, requests: 1},
+		{name: "server error", statuses: []int{500}, wantAny: true, requests: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			requests := 0
+
+			release := recorded(t, "release.json")
+
+			mb := newTestMusicBrainz(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[requests]
+				requests++
+				mu.Unlock()
+
+				if status == http.StatusOK {
+					release(w, r)
+					return
+				}
+
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(status)
+			}))
+
+			_, err := mb.Lookup(context.Background(), Query{ReleaseID: "0e3d7f6a-2d71-4c6b-9d7d-5b4a1f0e8c21"})
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v; want %v", err, tt.wantErr)
+				}
+			case tt.wantAny:
+				if err == nil || errors.Is(err, ErrNoMatch) {
+					t.Errorf("err = %v; want a request error", err)
+				}
+			default:
+				if err != nil {
+					t.Errorf("err = %v; want nil", err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if requests != tt.requests {
+				t.Errorf("made %d requests; want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestMusicBrainzCanceledWhileRateLimited(t *testing.T) {
+	t.Parallel()
+
+	mb := newTestMusicBrainz(t, recorded(t, "release.json"))
+
+	_, err := mb.Lookup(context.Background(), Query{ReleaseID: "0e3d7f6a-2d71-4c6b-9d7d-5b4a1f0e8c21"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = mb.Lookup(ctx, Query{ReleaseID: "0e3d7f6a-2d71-4c6b-9d7d-5b4a1f0e8c21"})
+	if err == nil {
+		t.Error("got no error; want the lookup to give up waiting for the rate limiter")
+	}
+}
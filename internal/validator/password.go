@@ -0,0 +1,265 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//go:embed "data"
+var dataFS embed.FS
+
+// BreachSource looks up leaked password hashes using k-anonymity: only the
+// first five hex characters of the SHA-1 hash are sent, and the source
+// returns every known suffix in that range with its breach count.
+type BreachSource interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+// FileBreachSource reads ranges from a directory laid out like the output of
+// the Have I Been Pwned downloader: one <PREFIX>.txt file per range, with a
+// SUFFIX:COUNT line per hash.
+type FileBreachSource struct {
+	Dir string
+}
+
+func (s FileBreachSource) Range(prefix string) (map[string]int, error) {
+	f, err := os.Open(filepath.Join(s.Dir, strings.ToUpper(prefix)+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return map[string]int{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseRange(f)
+}
+
+// HTTPBreachSource queries a range API such as
+// https://api.pwnedpasswords.com/range/.
+type HTTPBreachSource struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (s HTTPBreachSource) Range(prefix string) (map[string]int, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	resp, err := client.Get(strings.TrimSuffix(s.BaseURL, "/") + "/" + strings.ToUpper(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("breach source returned %s", resp.Status)
+	}
+
+	return parseRange(resp.Body)
+}
+
+func parseRange(r io.Reader) (map[string]int, error) {
+	suffixes := map[string]int{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		suffix, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			continue
+		}
+
+		suffixes[strings.ToUpper(suffix)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return suffixes, nil
+}
+
+func BreachCount(source BreachSource, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(hash[:5])
+	if err != nil {
+		return 0, err
+	}
+
+	return suffixes[hash[5:]], nil
+}
+
+type PasswordPolicy struct {
+	MinEntropy float64
+	Blocklist  map[string]bool
+	Breaches   BreachSource
+}
+
+func NewPasswordPolicy(minEntropy float64, breaches BreachSource) (*PasswordPolicy, error) {
+	f, err := dataFS.Open("data/common_passwords.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policy := &PasswordPolicy{
+		MinEntropy: minEntropy,
+		Blocklist:  map[string]bool{},
+		Breaches:   breaches,
+	}
+
+	err = policy.AddBlocklist(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (p *PasswordPolicy) AddBlocklist(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word != "" {
+			p.Blocklist[word] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Validate adds a field error under key for every rule the password breaks.
+// userInputs are personal values such as the user's name and email which the
+// password must not contain. The returned error is only non-nil when the
+// breach source could not be queried.
+func (p *PasswordPolicy) Validate(v *Validator, key, password string, userInputs ...string) error {
+	lower := strings.ToLower(password)
+
+	v.Check(!p.Blocklist[lower], key, "is too common")
+
+	for _, input := range personalTokens(userInputs) {
+		if strings.Contains(lower, input) {
+			v.AddError(key, "must not contain your name or email")
+			break
+		}
+	}
+
+	v.Check(PasswordEntropy(password) >= p.MinEntropy, key, "is too easy to guess")
+
+	if p.Breaches == nil || !v.Valid() {
+		return nil
+	}
+
+	count, err := BreachCount(p.Breaches, password)
+	if err != nil {
+		return err
+	}
+
+	v.Check(count == 0, key, "has appeared in a data breach")
+	return nil
+}
+
+func personalTokens(inputs []string) []string {
+	var tokens []string
+
+	for _, input := range inputs {
+		input = strings.ToLower(input)
+		if local, _, found := strings.Cut(input, "@"); found {
+			tokens = append(tokens, input)
+			input = local
+		}
+
+		for _, field := range strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(field) >= 3 {
+				tokens = append(tokens, field)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// PasswordEntropy estimates the strength of a password in bits from the size
+// of the character pool it draws on. Only the first two characters of a run
+// such as "aaaa" or "1234" count, so padding a weak password doesn't help.
+func PasswordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	var prev rune
+	length, run, step := 0, 1, 0
+
+	for i, r := range []rune(password) {
+		switch {
+		case unicode.IsLower(r) && r < unicode.MaxASCII:
+			lower = true
+		case unicode.IsUpper(r) && r < unicode.MaxASCII:
+			upper = true
+		case unicode.IsDigit(r) && r < unicode.MaxASCII:
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		if i > 0 {
+			d := int(r - prev)
+			if d >= -1 && d <= 1 && (run == 1 || d == step) {
+				step = d
+				run++
+			} else {
+				run = 1
+			}
+		}
+		prev = r
+
+		if run <= 2 {
+			length++
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
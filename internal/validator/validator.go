@@ -4,6 +4,8 @@ import "regexp"
 
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	// CurrencyRX matches ISO 4217 currency codes, such as USD.
+	CurrencyRX = regexp.MustCompile("^[A-Z]{3}$")
 )
 
 type Validator struct {
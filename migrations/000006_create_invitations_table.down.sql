@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS invitations;
+
+DELETE FROM permissions WHERE code = 'invitations:write';
\ No newline at end of file
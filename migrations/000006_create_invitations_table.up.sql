@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    hash bytea PRIMARY KEY,
+    email citext NOT NULL,
+    permissions text[] NOT NULL DEFAULT '{}',
+    created_by bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+INSERT INTO permissions (code) VALUES
+('invitations:write');
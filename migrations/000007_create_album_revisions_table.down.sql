@@ -0,0 +1 @@
+DROP TABLE IF EXISTS album_revisions;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS album_revisions (
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    version integer NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    action text NOT NULL,
+    restored_from integer,
+    title text NOT NULL,
+    artist text NOT NULL,
+    genres text[] NOT NULL,
+    changes jsonb NOT NULL DEFAULT '{}',
+    PRIMARY KEY (album_id, version)
+);
+
+INSERT INTO album_revisions (album_id, version, created_at, action, title, artist, genres)
+SELECT id, version, created_at, 'create', title, artist, genres
+FROM albums
+ON CONFLICT DO NOTHING;
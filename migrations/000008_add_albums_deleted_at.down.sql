@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'albums:purge';
+
+DROP INDEX IF EXISTS albums_deleted_at_idx;
+
+ALTER TABLE albums DROP COLUMN IF EXISTS deleted_at;
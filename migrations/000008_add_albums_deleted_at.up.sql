@@ -0,0 +1,6 @@
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS albums_deleted_at_idx ON albums (deleted_at) WHERE deleted_at IS NOT NULL;
+
+INSERT INTO permissions (code) VALUES
+('albums:purge');
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) with time zone NOT NULL,
+    actor_id bigint,
+    ip text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    action text NOT NULL,
+    target_type text NOT NULL,
+    target_id bigint,
+    diff json NOT NULL,
+    prev_hash text NOT NULL,
+    hash text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+INSERT INTO permissions (code) VALUES
+('audit:read');
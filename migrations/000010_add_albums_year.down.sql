@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS albums_year_idx;
+
+ALTER TABLE album_revisions DROP COLUMN IF EXISTS year;
+ALTER TABLE albums DROP COLUMN IF EXISTS year;
@@ -0,0 +1,4 @@
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS year integer;
+ALTER TABLE album_revisions ADD COLUMN IF NOT EXISTS year integer;
+
+CREATE INDEX IF NOT EXISTS albums_year_idx ON albums (year);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS albums_search_vector_idx;
+DROP TRIGGER IF EXISTS albums_search_vector_trigger ON albums;
+DROP FUNCTION IF EXISTS albums_search_vector_update();
+ALTER TABLE albums DROP COLUMN IF EXISTS search_vector;
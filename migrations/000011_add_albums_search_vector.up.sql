@@ -0,0 +1,20 @@
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION albums_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(NEW.artist, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(array_to_string(NEW.genres, ' '), '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS albums_search_vector_trigger ON albums;
+CREATE TRIGGER albums_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title, artist, genres ON albums
+    FOR EACH ROW EXECUTE FUNCTION albums_search_vector_update();
+
+UPDATE albums SET title = title;
+
+CREATE INDEX IF NOT EXISTS albums_search_vector_idx ON albums USING GIN (search_vector);
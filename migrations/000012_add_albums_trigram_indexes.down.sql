@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS albums_title_trgm_idx;
+DROP INDEX IF EXISTS albums_artist_trgm_idx;
+
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,4 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS albums_title_trgm_idx ON albums USING GIN (lower(title) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS albums_artist_trgm_idx ON albums USING GIN (lower(artist) gin_trgm_ops);
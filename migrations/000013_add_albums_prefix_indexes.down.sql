@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS albums_title_prefix_idx;
+DROP INDEX IF EXISTS albums_artist_prefix_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS albums_title_prefix_idx ON albums (lower(title) text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS albums_artist_prefix_idx ON albums (lower(artist) text_pattern_ops) WHERE deleted_at IS NULL;
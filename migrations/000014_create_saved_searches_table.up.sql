@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    query text NOT NULL,
+    subscribed boolean NOT NULL DEFAULT false,
+    last_album_id bigint NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS saved_searches_subscribed_idx ON saved_searches (id) WHERE subscribed;
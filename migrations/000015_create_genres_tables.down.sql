@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'genres:write';
+
+DROP TABLE IF EXISTS genre_aliases;
+DROP TABLE IF EXISTS genres;
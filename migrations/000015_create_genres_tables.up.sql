@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    key text NOT NULL UNIQUE,
+    parent_id bigint REFERENCES genres ON DELETE SET NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS genres_parent_id_idx ON genres (parent_id);
+
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    key text PRIMARY KEY,
+    alias text NOT NULL,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS genre_aliases_genre_id_idx ON genre_aliases (genre_id);
+
+-- Seed the taxonomy from the genres already in use, picking the most common
+-- spelling of each, then rewrite albums to use those spellings.
+INSERT INTO genres (name, key)
+SELECT DISTINCT ON (key) genre, key
+FROM (
+    SELECT genre, lower(regexp_replace(genre, '[^[:alnum:]]', '', 'g')) AS key, count(*) AS uses
+    FROM albums, unnest(genres) AS genre
+    GROUP BY genre
+) AS spellings
+WHERE key <> ''
+ORDER BY key, uses DESC, genre;
+
+UPDATE albums SET genres = ARRAY(
+    SELECT g.name
+    FROM unnest(albums.genres) WITH ORDINALITY AS a(genre, position)
+    INNER JOIN genres g ON g.key = lower(regexp_replace(a.genre, '[^[:alnum:]]', '', 'g'))
+    GROUP BY g.name
+    ORDER BY min(a.position)
+)
+WHERE genres <> '{}';
+
+INSERT INTO permissions (code) VALUES
+('genres:write');
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS album_tags (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    tag text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, album_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS album_tags_user_id_tag_idx ON album_tags (user_id, tag);
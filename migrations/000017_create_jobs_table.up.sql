@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    status text NOT NULL DEFAULT 'queued',
+    total integer NOT NULL DEFAULT 0,
+    processed integer NOT NULL DEFAULT 0,
+    result json,
+    error text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS jobs_user_id_idx ON jobs (user_id);
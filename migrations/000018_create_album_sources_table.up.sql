@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS album_sources (
+    source text NOT NULL,
+    external_id text NOT NULL,
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    metadata json NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS album_sources_album_id_idx ON album_sources (album_id);
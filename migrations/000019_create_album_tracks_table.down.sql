@@ -0,0 +1,3 @@
+ALTER TABLE albums DROP COLUMN IF EXISTS enriched_at;
+
+DROP TABLE IF EXISTS album_tracks;
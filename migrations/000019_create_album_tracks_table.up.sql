@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS album_tracks (
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    disc integer NOT NULL DEFAULT 1,
+    position integer NOT NULL,
+    title text NOT NULL,
+    length_ms integer,
+    PRIMARY KEY (album_id, disc, position)
+);
+
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS enriched_at timestamp(0) with time zone;
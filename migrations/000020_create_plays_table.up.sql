@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS plays (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    track text NOT NULL DEFAULT '',
+    played_at timestamp(0) with time zone NOT NULL,
+    source text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Resubmitting a play, as offline clients may, doesn't record it twice.
+CREATE UNIQUE INDEX IF NOT EXISTS plays_user_id_album_id_played_at_track_idx ON plays (user_id, album_id, played_at, track);
+CREATE INDEX IF NOT EXISTS plays_user_id_played_at_idx ON plays (user_id, played_at);
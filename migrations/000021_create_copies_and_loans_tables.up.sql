@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS copies (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    condition text NOT NULL DEFAULT '',
+    notes text NOT NULL DEFAULT '',
+    acquired_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS copies_user_id_idx ON copies (user_id);
+CREATE INDEX IF NOT EXISTS copies_album_id_idx ON copies (album_id);
+
+CREATE TABLE IF NOT EXISTS loans (
+    id bigserial PRIMARY KEY,
+    copy_id bigint NOT NULL REFERENCES copies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    borrower_name text NOT NULL,
+    borrower_contact text NOT NULL DEFAULT '',
+    borrower_id bigint REFERENCES users ON DELETE SET NULL,
+    loaned_at timestamp(0) with time zone NOT NULL,
+    due_at timestamp(0) with time zone,
+    returned_at timestamp(0) with time zone,
+    reminded_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+-- A copy can only be out with one borrower at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS loans_copy_id_open_idx ON loans (copy_id) WHERE returned_at IS NULL;
+CREATE INDEX IF NOT EXISTS loans_user_id_idx ON loans (user_id);
+CREATE INDEX IF NOT EXISTS loans_borrower_id_idx ON loans (borrower_id);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'prices:write';
+
+DROP TABLE IF EXISTS exchange_rates;
+DROP TABLE IF EXISTS price_observations;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS price_observations (
+    id bigserial PRIMARY KEY,
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    source text NOT NULL,
+    condition text NOT NULL DEFAULT '',
+    currency text NOT NULL,
+    amount numeric(12, 2) NOT NULL,
+    observed_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS price_observations_album_id_observed_at_idx ON price_observations (album_id, observed_at DESC);
+
+-- rate is the value of one unit of currency in the configured base currency.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency text PRIMARY KEY,
+    rate numeric(18, 8) NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO permissions (code) VALUES
+('prices:write');
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS user_privacy (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    slug citext UNIQUE,
+    collection_public boolean NOT NULL DEFAULT false,
+    show_name boolean NOT NULL DEFAULT false,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS share_links (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash bytea NOT NULL UNIQUE,
+    name text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone,
+    revoked_at timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS share_links_user_id_idx ON share_links (user_id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS search_settings (
+    id boolean PRIMARY KEY DEFAULT TRUE CHECK (id),
+    config regconfig NOT NULL
+);
+
+INSERT INTO search_settings (config) VALUES ('english') ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION albums_search_vector_update() RETURNS trigger AS $$
+DECLARE
+    config regconfig := COALESCE((SELECT s.config FROM search_settings s), 'english');
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(config, coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(config, coalesce(NEW.artist, '')), 'B') ||
+        setweight(to_tsvector(config, coalesce(array_to_string(NEW.genres, ' '), '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,18 @@
+DROP TRIGGER IF EXISTS album_tracks_search_vector_delete ON album_tracks;
+DROP TRIGGER IF EXISTS album_tracks_search_vector_update ON album_tracks;
+DROP TRIGGER IF EXISTS album_tracks_search_vector_insert ON album_tracks;
+DROP FUNCTION IF EXISTS album_tracks_search_vector_update();
+
+CREATE OR REPLACE FUNCTION albums_search_vector_update() RETURNS trigger AS $$
+DECLARE
+    config regconfig := COALESCE((SELECT s.config FROM search_settings s), 'english');
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(config, coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(config, coalesce(NEW.artist, '')), 'B') ||
+        setweight(to_tsvector(config, coalesce(array_to_string(NEW.genres, ' '), '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+UPDATE albums SET title = title WHERE id IN (SELECT DISTINCT album_id FROM album_tracks);
@@ -0,0 +1,39 @@
+CREATE OR REPLACE FUNCTION albums_search_vector_update() RETURNS trigger AS $$
+DECLARE
+    config regconfig := COALESCE((SELECT s.config FROM search_settings s), 'english');
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(config, coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(config, coalesce(NEW.artist, '')), 'B') ||
+        setweight(to_tsvector(config, coalesce(array_to_string(NEW.genres, ' '), '')), 'C') ||
+        setweight(to_tsvector(config, coalesce((SELECT string_agg(t.title, ' ') FROM album_tracks t WHERE t.album_id = NEW.id), '')), 'D');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION album_tracks_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE albums SET title = title WHERE id IN (SELECT DISTINCT album_id FROM changed_tracks);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS album_tracks_search_vector_insert ON album_tracks;
+CREATE TRIGGER album_tracks_search_vector_insert
+    AFTER INSERT ON album_tracks
+    REFERENCING NEW TABLE AS changed_tracks
+    FOR EACH STATEMENT EXECUTE FUNCTION album_tracks_search_vector_update();
+
+DROP TRIGGER IF EXISTS album_tracks_search_vector_update ON album_tracks;
+CREATE TRIGGER album_tracks_search_vector_update
+    AFTER UPDATE ON album_tracks
+    REFERENCING NEW TABLE AS changed_tracks
+    FOR EACH STATEMENT EXECUTE FUNCTION album_tracks_search_vector_update();
+
+DROP TRIGGER IF EXISTS album_tracks_search_vector_delete ON album_tracks;
+CREATE TRIGGER album_tracks_search_vector_delete
+    AFTER DELETE ON album_tracks
+    REFERENCING OLD TABLE AS changed_tracks
+    FOR EACH STATEMENT EXECUTE FUNCTION album_tracks_search_vector_update();
+
+UPDATE albums SET title = title WHERE id IN (SELECT DISTINCT album_id FROM album_tracks);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS albums_genres_idx;
+DROP INDEX IF EXISTS genre_aliases_key_prefix_idx;
+DROP INDEX IF EXISTS genres_key_prefix_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS genres_key_prefix_idx ON genres (key text_pattern_ops);
+CREATE INDEX IF NOT EXISTS genre_aliases_key_prefix_idx ON genre_aliases (key text_pattern_ops);
+CREATE INDEX IF NOT EXISTS albums_genres_idx ON albums USING GIN (genres) WHERE deleted_at IS NULL;